@@ -0,0 +1,87 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cli"
+	"github.com/webern/tftp/lib/cor"
+)
+
+const usage = `usage:
+  tftp [flags] get <remote file> [local file]
+  tftp [flags] put <local file> [remote file]
+  tftp [flags] batch <manifest file>
+
+A local file of - is stdout for get and stdin for put. Each line of a manifest is a get or put command as above,
+blank lines and lines starting with # are ignored.
+
+flags:
+`
+
+// ProgramArgs represents the command line arguments after they have been parsed
+type ProgramArgs struct {
+	Server       string   // The host of the server, with an optional port
+	Mode         string   // The transfer mode, octet or netascii
+	BlockSize    int      // The block size to negotiate with the blksize option
+	WindowSize   int      // The window size to negotiate with the windowsize option
+	Timeout      int      // The number of seconds to wait before retransmitting, negotiated with the timeout option
+	Retries      int      // The number of times to retransmit before abandoning a transfer
+	Rollover     int      // The block number that follows 65535, negotiated with the rollover option
+	TransferSize bool     // Negotiates the tsize option so that progress can be shown as a percentage
+	Quiet        bool     // Suppresses progress output
+	Command      string   // get, put or batch
+	Operands     []string // The files named after the command
+}
+
+// parseArgs parses args, which do not include the program name. Usage and errors are written to output.
+func parseArgs(args []string, output io.Writer) (ProgramArgs, error) {
+	a := ProgramArgs{}
+	flags := flag.NewFlagSet("tftp", flag.ContinueOnError)
+	flags.SetOutput(output)
+	flags.Usage = func() {
+		_, _ = fmt.Fprint(output, usage)
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&a.Server, "server", "", fmt.Sprintf("the host of the tftp server, with an optional port. the port defaults to %d", cli.DefaultPort))
+	flags.StringVar(&a.Mode, "mode", cor.ModeOctet, "the transfer mode, octet or netascii")
+	flags.IntVar(&a.BlockSize, "blksize", cor.BlockSize, "the block size to negotiate with the server, 8 to 65464")
+	flags.IntVar(&a.WindowSize, "windowsize", 1, "the number of blocks to send or receive per acknowledgement, 1 to 65535")
+	flags.IntVar(&a.Timeout, "timeout", 0, "the number of seconds to wait before retransmitting, 1 to 255. 0 uses the default without negotiating it")
+	flags.IntVar(&a.Retries, "retries", cli.DefaultRetries, "the number of times to retransmit before abandoning a transfer")
+	flags.IntVar(&a.Rollover, "rollover", 0, "the block number that follows 65535 in large transfers, 0 or 1")
+	flags.BoolVar(&a.TransferSize, "tsize", true, "ask the server for the size of the file so that progress can be shown as a percentage")
+	flags.BoolVar(&a.Quiet, "quiet", false, "do not show progress")
+
+	if err := flags.Parse(args); err != nil {
+		return a, err
+	}
+
+	if len(a.Server) == 0 {
+		flags.Usage()
+		return a, flog.Raise("the -server flag is required")
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return a, flog.Raise("a command is required")
+	}
+
+	a.Command = flags.Arg(0)
+	a.Operands = flags.Args()[1:]
+
+	if a.Timeout < 0 || a.Timeout > cor.MaxTimeout {
+		return a, flog.Raisef("the timeout %d is outside the range 0 to %d", a.Timeout, cor.MaxTimeout)
+	}
+
+	if a.Rollover != 0 && a.Rollover != 1 {
+		return a, flog.Raisef("the rollover %d is not 0 or 1", a.Rollover)
+	}
+
+	return a, nil
+}
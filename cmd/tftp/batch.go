@@ -0,0 +1,82 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/webern/flog"
+)
+
+// parseManifest reads a job from each line of r. Blank lines and lines starting with # are ignored.
+func parseManifest(r io.Reader) ([]job, error) {
+	var jobs []job
+	scanner := bufio.NewScanner(r)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		j, err := parseJob(fields[0], fields[1:])
+
+		if err != nil {
+			return nil, flog.Raisef("line %d: %s", lineNum, err.Error())
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	return jobs, nil
+}
+
+// batch runs each job in the manifest at path, one after another. A failed job is reported and the rest still run,
+// and an error is returned at the end if any failed. The manifest is checked in full before any job runs.
+func (r *runner) batch(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return flog.Wrap(err)
+	}
+
+	jobs, err := parseManifest(f)
+	_ = f.Close()
+
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, j := range jobs {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if err = r.run(ctx, j); err != nil {
+			failed++
+
+			if r.quiet {
+				// progress output reports failures, without it they must be reported here
+				_, _ = fmt.Fprintf(r.stderr, "%s %s: %s\n", j.command, j.remote, err.Error())
+			}
+		}
+	}
+
+	if failed > 0 {
+		return flog.Raisef("%d of %d transfers failed", failed, len(jobs))
+	}
+
+	return nil
+}
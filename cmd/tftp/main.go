@@ -0,0 +1,35 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+
+	"github.com/webern/flog"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// control-c cancels the transfer in progress, letting the server know that it has been abandoned
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+
+	go func() {
+		<-sigChan
+		cancel()
+	}()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	cancel()
+
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		flog.Error(err.Error())
+		os.Exit(1)
+	}
+}
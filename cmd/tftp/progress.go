@@ -0,0 +1,61 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// progressInterval is the least time between progress updates, so that small blocks do not flood the terminal
+const progressInterval = 100 * time.Millisecond
+
+// progress writes the progress of a transfer to w on a single line that is rewritten as the transfer proceeds
+type progress struct {
+	w       io.Writer
+	name    string
+	last    time.Time // when the line was last written
+	lineLen int       // the length of the line last written, so that a shorter line can blank out its remains
+}
+
+func newProgress(w io.Writer, name string) *progress {
+	return &progress{w: w, name: name}
+}
+
+// update is called as blocks are transferred. size is -1 if the size of the file is not known.
+func (p *progress) update(transferred, size int64) {
+	if now := time.Now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+
+		if size > 0 {
+			p.print(fmt.Sprintf("%s: %d of %d bytes (%d%%)", p.name, transferred, size, transferred*100/size))
+		} else {
+			p.print(fmt.Sprintf("%s: %d bytes", p.name, transferred))
+		}
+	}
+}
+
+// finish writes the outcome of the transfer and ends the line
+func (p *progress) finish(transferred int64, elapsed time.Duration, err error) {
+	if err != nil {
+		p.print(fmt.Sprintf("%s: failed after %d bytes: %s", p.name, transferred, err.Error()))
+	} else {
+		p.print(fmt.Sprintf("%s: %d bytes in %s", p.name, transferred, elapsed.Round(time.Millisecond).String()))
+	}
+
+	_, _ = fmt.Fprint(p.w, "\n")
+}
+
+// print rewrites the line with s
+func (p *progress) print(s string) {
+	padding := ""
+
+	if len(s) < p.lineLen {
+		padding = strings.Repeat(" ", p.lineLen-len(s))
+	}
+
+	p.lineLen = len(s)
+	_, _ = fmt.Fprintf(p.w, "\r%s%s", s, padding)
+}
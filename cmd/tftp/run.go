@@ -0,0 +1,192 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cli"
+)
+
+// job is a single get or put
+type job struct {
+	command string // get or put
+	remote  string // the name of the file on the server
+	local   string // the path of the local file, - for stdin or stdout
+}
+
+// run is the main logic of the tftp program. args do not include the program name. stdin and stdout stand in for a
+// local file named -, and progress is written to stderr.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	programArgs, err := parseArgs(args, stderr)
+
+	if err != nil {
+		return err
+	}
+
+	flog.SetTruncationPath("tftp/")
+	client := cli.NewClient(programArgs.Server)
+	client.Mode = programArgs.Mode
+	client.BlockSize = programArgs.BlockSize
+	client.WindowSize = programArgs.WindowSize
+	client.Timeout = time.Duration(programArgs.Timeout) * time.Second
+	client.Retries = programArgs.Retries
+	client.Rollover = programArgs.Rollover
+	client.TransferSize = programArgs.TransferSize
+
+	r := runner{client: client, stdin: stdin, stdout: stdout, stderr: stderr, quiet: programArgs.Quiet}
+
+	if programArgs.Command == "batch" {
+		if len(programArgs.Operands) != 1 {
+			return flog.Raise("batch requires a single manifest file")
+		}
+
+		return r.batch(ctx, programArgs.Operands[0])
+	}
+
+	j, err := parseJob(programArgs.Command, programArgs.Operands)
+
+	if err != nil {
+		return err
+	}
+
+	return r.run(ctx, j)
+}
+
+// parseJob makes a job from a command and the files named after it. When only one file is named, the other takes its
+// base name.
+func parseJob(command string, operands []string) (job, error) {
+	if command != "get" && command != "put" {
+		return job{}, flog.Raisef("unknown command '%s'", command)
+	}
+
+	if len(operands) < 1 || len(operands) > 2 {
+		return job{}, flog.Raisef("%s requires one or two files", command)
+	}
+
+	j := job{command: command}
+
+	if command == "get" {
+		j.remote = operands[0]
+		j.local = filepath.Base(j.remote)
+	} else {
+		j.local = operands[0]
+		j.remote = filepath.Base(j.local)
+	}
+
+	if len(operands) == 2 && command == "get" {
+		j.local = operands[1]
+	} else if len(operands) == 2 {
+		j.remote = operands[1]
+	} else if j.local == "-" {
+		return job{}, flog.Raisef("%s requires a remote file name when the local file is -", command)
+	}
+
+	return j, nil
+}
+
+// runner runs jobs with a client
+type runner struct {
+	client cli.Client
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	quiet  bool
+}
+
+// run transfers a single file
+func (r *runner) run(ctx context.Context, j job) error {
+	client := r.client
+	var p *progress
+
+	if !r.quiet {
+		p = newProgress(r.stderr, j.remote)
+		client.Progress = p.update
+	}
+
+	var n int64
+	var err error
+	start := time.Now()
+
+	if j.command == "get" {
+		n, err = r.get(ctx, &client, j)
+	} else {
+		n, err = r.put(ctx, &client, j)
+	}
+
+	if p != nil {
+		p.finish(n, time.Since(start), err)
+	}
+
+	return err
+}
+
+// get reads j.remote from the server into j.local. The file is downloaded next to j.local and renamed over it only
+// once the transfer succeeds, so a failed transfer leaves any existing local file as it was.
+func (r *runner) get(ctx context.Context, client *cli.Client, j job) (int64, error) {
+	if j.local == "-" {
+		return client.Get(ctx, j.remote, r.stdout)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(j.local), "."+filepath.Base(j.local)+".*.tmp")
+
+	if err != nil {
+		return 0, flog.Wrap(err)
+	}
+
+	n, err := client.Get(ctx, j.remote, tmp)
+
+	// ioutil.TempFile creates files that only the owner can read, a file that is replaced keeps its permissions
+	mode := os.FileMode(0644)
+
+	if info, statErr := os.Stat(j.local); statErr == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), j.local)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return n, err
+	}
+
+	return n, nil
+}
+
+// put writes j.local to the server as j.remote
+func (r *runner) put(ctx context.Context, client *cli.Client, j job) (int64, error) {
+	if j.local == "-" {
+		return client.Put(ctx, j.remote, r.stdin, -1)
+	}
+
+	f, err := os.Open(j.local)
+
+	if err != nil {
+		return 0, flog.Wrap(err)
+	}
+
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+
+	if err != nil {
+		return 0, flog.Wrap(err)
+	}
+
+	return client.Put(ctx, j.remote, f, info.Size())
+}
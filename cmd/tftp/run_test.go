@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/srv"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// startServer runs a tftp server with a memStore on port, returning a function that stops it
+func startServer(t *testing.T, port int) func() {
+	server := srv.NewServer(stor.NewMemStore())
+	server.Port = port
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if err := server.Serve(); err != nil {
+			flog.Error(err.Error())
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	return func() {
+		_ = server.Stop()
+		<-done
+	}
+}
+
+func TestParseJob(t *testing.T) {
+	tests := []struct {
+		command  string
+		operands []string
+		want     job
+		ok       bool
+	}{
+		{"get", []string{"dir/remote.bin"}, job{"get", "dir/remote.bin", "remote.bin"}, true},
+		{"get", []string{"remote.bin", "local.bin"}, job{"get", "remote.bin", "local.bin"}, true},
+		{"get", []string{"remote.bin", "-"}, job{"get", "remote.bin", "-"}, true},
+		{"put", []string{"/tmp/local.bin"}, job{"put", "local.bin", "/tmp/local.bin"}, true},
+		{"put", []string{"local.bin", "remote.bin"}, job{"put", "remote.bin", "local.bin"}, true},
+		{"put", []string{"-"}, job{}, false},
+		{"get", nil, job{}, false},
+		{"get", []string{"a", "b", "c"}, job{}, false},
+		{"delete", []string{"a"}, job{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseJob(test.command, test.operands)
+
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s %v should have been refused", test.command, test.operands)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s %v: %s", test.command, test.operands, err.Error())
+		} else if got != test.want {
+			t.Errorf("%s %v: got %+v, want %+v", test.command, test.operands, got, test.want)
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	manifest := "# configs for the lab\n\nget router.cfg\n  put backup.cfg switch.cfg  \n"
+	jobs, err := parseManifest(strings.NewReader(manifest))
+
+	if msg, ok := tcore.TErr("parseManifest", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("len(jobs)", len(jobs), 2); !ok {
+		t.Fatal(msg)
+	}
+
+	if jobs[1] != (job{"put", "switch.cfg", "backup.cfg"}) {
+		t.Errorf("got %+v", jobs[1])
+	}
+
+	_, err = parseManifest(strings.NewReader("get a\nfetch b\n"))
+
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("want an error on line 2, got %v", err)
+	}
+}
+
+func TestRunPutGet(t *testing.T) {
+	stop := startServer(t, 11301)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "tftp-run")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	data := bytes.Repeat([]byte("0123456789"), 500)
+	local := filepath.Join(dir, "upload.bin")
+
+	if err = ioutil.WriteFile(local, data, 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	stderr := bytes.Buffer{}
+	args := []string{"-server", "127.0.0.1:11301", "-blksize", "1024", "-windowsize", "4", "put", local}
+
+	if err = run(context.Background(), args, nil, nil, &stderr); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.Contains(stderr.String(), fmt.Sprintf("upload.bin: %d bytes in", len(data))) {
+		t.Errorf("unexpected progress output %q", stderr.String())
+	}
+
+	downloaded := filepath.Join(dir, "download.bin")
+	args = []string{"-server", "127.0.0.1:11301", "-quiet", "get", "upload.bin", downloaded}
+
+	if err = run(context.Background(), args, nil, nil, &stderr); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, err := ioutil.ReadFile(downloaded)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Error("the file downloaded is not the file uploaded")
+	}
+
+	// a failed get leaves no local file behind
+	missing := filepath.Join(dir, "missing.bin")
+	args = []string{"-server", "127.0.0.1:11301", "-quiet", "get", "missing.bin", missing}
+
+	if err = run(context.Background(), args, nil, nil, &stderr); err == nil {
+		t.Error("getting a file that does not exist should fail")
+	}
+
+	if _, err = os.Stat(missing); !os.IsNotExist(err) {
+		t.Error("the local file of a failed get should have been removed")
+	}
+
+	// a failed get leaves an existing local file as it was
+	args = []string{"-server", "127.0.0.1:11301", "-quiet", "get", "missing.bin", local}
+
+	if err = run(context.Background(), args, nil, nil, &stderr); err == nil {
+		t.Error("getting a file that does not exist should fail")
+	}
+
+	if got, err = ioutil.ReadFile(local); err != nil || !bytes.Equal(got, data) {
+		t.Error("a failed get should not have touched the existing local file")
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if msg, ok := tcore.TAssertInt("len(entries)", len(entries), 2); !ok {
+		t.Errorf("temporary files were left behind: %s", msg)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	stop := startServer(t, 11302)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "tftp-batch")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, name := range []string{"a.cfg", "b.cfg"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("contents of "+name), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	manifest := fmt.Sprintf("# upload then fetch back\nput %s\nput %s\nget missing.cfg %s\nget b.cfg %s\n",
+		filepath.Join(dir, "a.cfg"), filepath.Join(dir, "b.cfg"), filepath.Join(dir, "missing.cfg"),
+		filepath.Join(dir, "b-copy.cfg"))
+	manifestPath := filepath.Join(dir, "manifest.txt")
+
+	if err = ioutil.WriteFile(manifestPath, []byte(manifest), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	args := []string{"-server", "127.0.0.1:11302", "batch", manifestPath}
+	err = run(context.Background(), args, nil, &stdout, &stderr)
+
+	// the missing file fails, but the jobs after it still run
+	if err == nil || !strings.Contains(err.Error(), "1 of 4") {
+		t.Errorf("want 1 of 4 transfers to fail, got %v", err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "b-copy.cfg"))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if msg, ok := tcore.TAssertString("b-copy.cfg", string(got), "contents of b.cfg"); !ok {
+		t.Error(msg)
+	}
+}
@@ -4,14 +4,41 @@ package main
 
 import (
 	"flag"
+	"time"
+
+	"github.com/webern/tftp/lib/srv"
 )
 
 // ProgramArgs represents the command line arguments after they have been parsed
 type ProgramArgs struct {
-	LogFilePath string // LogFilePath tells the server where to write the connection log
-	Port        int    // The listening port, defaults to 69 per TFTP standard
-	Verbose     bool   // Sets the stdout logging to 'trace'. Does not affect the connection log
-	Quiet       bool   // Sets the stdout logging to 'error'. Does not affect the connection log
+	LogFilePath   string   // LogFilePath tells the server where to write the connection log
+	Port          int      // The listening port, defaults to 69 per TFTP standard
+	Verbose       bool     // Sets the stdout logging to 'trace'. Does not affect the connection log
+	Quiet         bool     // Sets the stdout logging to 'error'. Does not affect the connection log
+	MaxBlockSize  int      // The largest block size the server will agree to when a client negotiates blksize
+	MaxFileSize   int64    // The largest file a client may upload, 0 for no limit
+	Timeout       int      // The default number of seconds to wait before retransmitting
+	Retries       int      // The number of times to retransmit before abandoning a transfer
+	MaxWindow     int      // The largest window a client may negotiate with windowsize
+	Rollover      int      // The block number that follows 65535, 0 or 1
+	Root          string   // The directory to serve files from, files are held in memory if it is empty
+	Archive       string   // A tar, tar.gz or zip archive to serve files from, read-only
+	MemBudget     int64    // The most bytes held in memory when Root and Archive are empty, 0 for no limit
+	Snapshot      string   // The file the in-memory files are loaded from at startup and saved to when stopping
+	WritePolicy   string   // Whether uploads may replace files, create new ones only, or are refused
+	ReadOnly      bool     // Refuse all uploads, e.g. for a boot server
+	Drain         int      // The number of seconds transfers in progress may take to finish when stopping
+	MaxTransfers  int      // The most transfers served at once, 0 for no limit
+	MaxPerClient  int      // The most transfers served at once to any one client IP address, 0 for no limit
+	QueueTimeout  int      // The number of seconds a request waits for a transfer to end when over a limit
+	MaxRate       int64    // The most bytes per second transferred overall, 0 for no limit
+	MaxClientRate int64    // The most bytes per second transferred to and from any one client IP address, 0 for no limit
+	WriteOnly     bool     // Refuse all downloads, e.g. for a drop box that collects logs
+	Mounts        []string // Stores to mount at prefixes of the file names, see openMount
+	MountFile     string   // A file listing more mounts, one per line
+	Rules         []string // Rules allowing or denying requests by client address, see srv.ParseRule
+	RuleFile      string   // A file listing more rules, one per line, checked after those in Rules
+	RemapFile     string   // A file listing rules that rewrite requested file names, see srv.ParseRemapRule
 }
 
 func parseArgs() ProgramArgs {
@@ -20,6 +47,30 @@ func parseArgs() ProgramArgs {
 	flag.IntVar(&a.Port, "port", 69, "the port the tftp server should listen on")
 	flag.BoolVar(&a.Verbose, "verbose", false, "increase the verbosity of logging to stdout. does not affect the connection logfile")
 	flag.BoolVar(&a.Quiet, "quiet", false, "decrease the verbosity of logging to stdout. does not affect the connection logfile")
+	flag.IntVar(&a.MaxBlockSize, "maxblksize", srv.TftpMaxPacketSize, "the largest block size a client may negotiate with the blksize option, 8 to 65464")
+	flag.Int64Var(&a.MaxFileSize, "maxfilesize", 0, "the largest file, in bytes, that a client may upload. 0 means no limit")
+	flag.IntVar(&a.Timeout, "timeout", int(srv.DefaultTimeout/time.Second), "the number of seconds to wait for a client before retransmitting, unless the client negotiates the timeout option")
+	flag.IntVar(&a.Retries, "retries", srv.DefaultRetries, "the number of times to retransmit before abandoning a transfer")
+	flag.IntVar(&a.MaxWindow, "maxwindowsize", srv.DefaultMaxWindowSize, "the largest number of blocks a client may negotiate with the windowsize option, 1 to 65535")
+	flag.IntVar(&a.Rollover, "rollover", 0, "the block number that follows 65535 in large transfers, 0 or 1. clients may override it with the rollover option")
+	flag.StringVar(&a.Root, "root", "", "the directory to serve files from and write uploads to. if blank, files are held in memory and lost when the server stops")
+	flag.StringVar(&a.Archive, "archive", "", "a tar, tar.gz or zip archive to serve files from without unpacking it. uploads are refused. cannot be combined with --root")
+	flag.Int64Var(&a.MemBudget, "membudget", 0, "the most bytes of files held in memory when --root is not given. the least recently used files are evicted to make room for new ones. 0 means no limit")
+	flag.StringVar(&a.Snapshot, "snapshot", "", "a file to load the in-memory files from when starting and save them to when stopping, when --root is not given")
+	flag.StringVar(&a.WritePolicy, "writepolicy", srv.WriteOverwrite.String(), "whether clients may upload files: 'overwrite' to replace files that exist, 'create' to refuse uploads of files that exist, 'none' to refuse all uploads")
+	flag.IntVar(&a.MaxTransfers, "maxtransfers", 0, "the most transfers served at once. 0 means no limit")
+	flag.IntVar(&a.MaxPerClient, "maxperclient", 0, "the most transfers served at once to any one client IP address. 0 means no limit")
+	flag.IntVar(&a.QueueTimeout, "queuetimeout", 0, "the number of seconds a request beyond --maxtransfers or --maxperclient waits for a transfer to end before it is refused. 0 refuses it at once")
+	flag.Int64Var(&a.MaxRate, "maxrate", 0, "the most bytes per second transferred over all transfers, in either direction. 0 means no limit")
+	flag.Int64Var(&a.MaxClientRate, "maxclientrate", 0, "the most bytes per second transferred to and from any one client IP address. 0 means no limit")
+	flag.IntVar(&a.Drain, "drain", 10, "when stopping, the number of seconds that transfers in progress may take to finish before they are abandoned")
+	flag.BoolVar(&a.ReadOnly, "readonly", false, "refuse all uploads with an access violation")
+	flag.BoolVar(&a.WriteOnly, "writeonly", false, "refuse all downloads with an access violation, e.g. for a drop box that collects logs")
+	flag.Var((*listFlags)(&a.Mounts), "mount", "mount a store at a prefix of the file names, as PREFIX=dir:PATH, PREFIX=archive:PATH or PREFIX=mem[:BUDGET], with ',ro' appended to refuse uploads. may be given more than once. names under no prefix are not found. cannot be combined with --root or --archive")
+	flag.StringVar(&a.MountFile, "mountfile", "", "a file listing mounts in the form of --mount, one per line. lines starting with # are ignored")
+	flag.Var((*listFlags)(&a.Rules), "rule", "allow or deny requests, as 'ACTION OP NETWORK [PATTERN]', e.g. 'allow rrq 10.0.0.0/8 pxe/*' or 'deny wrq any'. ACTION is allow or deny, OP is rrq, wrq or any, NETWORK is a CIDR, an IP address or any, and PATTERN matches file names. may be given more than once. the first rule that matches a request decides, and requests no rule matches are served")
+	flag.StringVar(&a.RuleFile, "rulefile", "", "a file listing rules in the form of --rule, one per line, checked after any --rule flags. lines starting with # are ignored")
+	flag.StringVar(&a.RemapFile, "remapfile", "", "a file listing rules that rewrite the file names clients ask for, one per line, applied in order, as 'ACTION OP [PATTERN [REPLACEMENT]]', e.g. 'backslash any', 'lower rrq' or 'replace any ^/?tftpboot/'. ACTION is replace, lower, upper or backslash and OP is rrq, wrq or any. lines starting with # are ignored")
 	flag.Parse()
 	return a
 }
@@ -0,0 +1,50 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/webern/flog"
+)
+
+// listFlags collects the values of a flag that may be given more than once, such as --mount
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// readListFile reads the lines of a file such as a --mountfile. Blank lines and lines starting with # are ignored.
+func readListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	defer func() { _ = f.Close() }()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	return lines, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// openMounts opens the store described by each spec and mounts them all in a mount store
+func openMounts(specs []string) (stor.Store, error) {
+	var mounts []stor.Mount
+
+	for _, spec := range specs {
+		mount, err := openMount(spec)
+
+		if err != nil {
+			for _, opened := range mounts {
+				opened.Store.Terminate()
+			}
+
+			return nil, err
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	store, err := stor.NewMountStore(mounts)
+
+	if err != nil {
+		for _, opened := range mounts {
+			opened.Store.Terminate()
+		}
+
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// openMount opens the store described by a spec of the form PREFIX=KIND[:ARG][,ro], where KIND is one of
+//
+//	dir:PATH       a directory
+//	archive:PATH   a tar, tar.gz or zip archive, which is always read-only
+//	mem[:BUDGET]   files held in memory, up to BUDGET bytes
+//
+// and ro makes the store read-only
+func openMount(spec string) (stor.Mount, error) {
+	eq := strings.Index(spec, "=")
+
+	if eq < 0 {
+		return stor.Mount{}, flog.Raisef("the mount '%s' should have the form PREFIX=KIND[:ARG][,ro]", spec)
+	}
+
+	prefix, backend := spec[:eq], spec[eq+1:]
+	readOnly := strings.HasSuffix(backend, ",ro")
+	backend = strings.TrimSuffix(backend, ",ro")
+	kind, arg := backend, ""
+
+	if colon := strings.Index(backend, ":"); colon >= 0 {
+		kind, arg = backend[:colon], backend[colon+1:]
+	}
+
+	var store stor.Store
+	var err error
+
+	switch kind {
+	case "dir":
+		store, err = stor.NewDirStore(arg)
+	case "archive":
+		store, err = stor.NewArchiveStore(arg)
+	case "mem":
+		var budget int64
+
+		if len(arg) > 0 {
+			budget, err = strconv.ParseInt(arg, 10, 64)
+		}
+
+		if err == nil {
+			store = stor.NewMemStore(stor.WithBudget(budget))
+		}
+	default:
+		err = flog.Raisef("unknown kind of store '%s'", kind)
+	}
+
+	if err != nil {
+		return stor.Mount{}, flog.Raisef("the mount '%s' could not be opened: %s", spec, err.Error())
+	}
+
+	if readOnly {
+		store = stor.ReadOnly(store)
+	}
+
+	return stor.Mount{Prefix: prefix, Store: store}, nil
+}
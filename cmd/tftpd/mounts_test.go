@@ -0,0 +1,83 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestOpenMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mounts")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+	_ = ioutil.WriteFile(filepath.Join(dir, "boot.img"), []byte("boot"), 0644)
+	mountFile := filepath.Join(dir, "mounts.conf")
+	content := "# boot images, never replaced\npxe=dir:" + dir + ",ro\n\nupload=mem:1000\n"
+
+	if err = ioutil.WriteFile(mountFile, []byte(content), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	specs, err := readListFile(mountFile)
+
+	if msg, ok := tcore.TErr("readListFile", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("len(specs)", len(specs), 2); !ok {
+		t.Fatal(msg)
+	}
+
+	store, err := openMounts(specs)
+
+	if msg, ok := tcore.TErr("openMounts", err); !ok {
+		t.Fatal(msg)
+	}
+
+	defer store.Terminate()
+
+	if _, err = store.Get("pxe/boot.img"); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err = store.Put(cor.File{Name: "pxe/boot.img", Data: []byte("x")}); err == nil {
+		t.Error("the read-only mount accepted a file")
+	}
+
+	if err = store.Put(cor.File{Name: "upload/device.log", Data: []byte("log")}); err != nil {
+		t.Error(err.Error())
+	}
+
+	if err = store.Put(cor.File{Name: "upload/huge.log", Data: make([]byte, 1001)}); err == nil {
+		t.Error("the upload mount accepted a file over its budget")
+	}
+}
+
+func TestOpenMountErrors(t *testing.T) {
+	specs := []string{
+		"pxe",
+		"pxe=floppy:/dev/fd0",
+		"pxe=mem:lots",
+		"pxe=dir:/this/does/not/exist",
+	}
+
+	for _, spec := range specs {
+		if _, err := openMount(spec); err == nil {
+			t.Errorf("openMount('%s') should fail", spec)
+		}
+	}
+
+	if _, err := openMounts([]string{"pxe=mem", "/pxe=mem"}); err == nil {
+		t.Error("two mounts at the same prefix should fail")
+	}
+}
@@ -3,11 +3,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/webern/flog"
 	"github.com/webern/tftp/lib/srv"
@@ -18,10 +20,114 @@ import (
 func run(sigChan chan os.Signal) error {
 	programArgs := parseArgs()
 	flog.SetTruncationPath("tftp/")
-	server := srv.NewServer(stor.NewMemStore())
+	store := stor.NewMemStore(stor.WithBudget(programArgs.MemBudget))
+	var err error
+
+	if len(programArgs.MountFile) > 0 {
+		specs, err := readListFile(programArgs.MountFile)
+
+		if err != nil {
+			return err
+		}
+
+		programArgs.Mounts = append(programArgs.Mounts, specs...)
+	}
+
+	if len(programArgs.RuleFile) > 0 {
+		lines, err := readListFile(programArgs.RuleFile)
+
+		if err != nil {
+			return err
+		}
+
+		programArgs.Rules = append(programArgs.Rules, lines...)
+	}
+
+	var rules []srv.Rule
+
+	for _, line := range programArgs.Rules {
+		rule, err := srv.ParseRule(line)
+
+		if err != nil {
+			return err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	var remapRules []srv.RemapRule
+
+	if len(programArgs.RemapFile) > 0 {
+		lines, err := readListFile(programArgs.RemapFile)
+
+		if err != nil {
+			return err
+		}
+
+		for _, line := range lines {
+			rule, err := srv.ParseRemapRule(line)
+
+			if err != nil {
+				return err
+			}
+
+			remapRules = append(remapRules, rule)
+		}
+	}
+
+	if programArgs.Rollover != 0 && programArgs.Rollover != 1 {
+		return flog.Raisef("--rollover must be 0 or 1, not %d", programArgs.Rollover)
+	} else if programArgs.ReadOnly && programArgs.WriteOnly {
+		return flog.Raise("--readonly and --writeonly cannot be used together")
+	} else if len(programArgs.Root) > 0 && len(programArgs.Archive) > 0 {
+		return flog.Raise("--root and --archive cannot be used together")
+	} else if len(programArgs.Mounts) > 0 && len(programArgs.Root)+len(programArgs.Archive) > 0 {
+		return flog.Raise("--mount cannot be used with --root or --archive")
+	} else if len(programArgs.Mounts) > 0 {
+		store, err = openMounts(programArgs.Mounts)
+	} else if len(programArgs.Root) > 0 {
+		store, err = stor.NewDirStore(programArgs.Root)
+	} else if len(programArgs.Archive) > 0 {
+		store, err = stor.NewArchiveStore(programArgs.Archive)
+	} else if len(programArgs.Snapshot) > 0 {
+		store, err = stor.LoadMemStore(programArgs.Snapshot, stor.WithBudget(programArgs.MemBudget))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	policy, err := srv.ParseWritePolicy(programArgs.WritePolicy)
+
+	if err != nil {
+		store.Terminate()
+		return err
+	}
+
+	server := srv.NewServer(store)
 	server.LogFilePath = programArgs.LogFilePath
 	server.Port = programArgs.Port
 	server.Verbose = programArgs.Verbose
+	server.MaxBlockSize = programArgs.MaxBlockSize
+	server.MaxFileSize = programArgs.MaxFileSize
+	server.Timeout = time.Duration(programArgs.Timeout) * time.Second
+	server.Retries = programArgs.Retries
+	server.MaxWindowSize = programArgs.MaxWindow
+	server.Rollover = programArgs.Rollover
+	server.WritePolicy = policy
+	server.MaxTransfers = programArgs.MaxTransfers
+	server.MaxTransfersPerClient = programArgs.MaxPerClient
+	server.QueueTimeout = time.Duration(programArgs.QueueTimeout) * time.Second
+	server.MaxRate = programArgs.MaxRate
+	server.MaxClientRate = programArgs.MaxClientRate
+	server.Rules = rules
+	server.Remap = remapRules
+
+	if programArgs.ReadOnly {
+		server.Access = srv.AccessReadOnly
+	} else if programArgs.WriteOnly {
+		server.Access = srv.AccessWriteOnly
+	}
 
 	if programArgs.Quiet {
 		flog.SetLevel(flog.ErrorLevel)
@@ -43,14 +149,25 @@ func run(sigChan chan os.Signal) error {
 		srvWait.Done()
 	}()
 
-	// listen for sigint (i.e. control-c) to stop the server
-	signal.Notify(sigChan, os.Interrupt)
+	// listen for sigint (i.e. control-c), or the sigterm that service managers and containers stop daemons with, to
+	// stop the server
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 	sig := <-sigChan
-	if sig == syscall.SIGINT {
-		fmt.Print("\n")
-		flog.Info("SIGINT received - stopping tftp server")
-		err := server.Stop()
-		if err != nil {
+	if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+		name := "SIGTERM"
+
+		if sig == syscall.SIGINT {
+			name = "SIGINT"
+			fmt.Print("\n")
+		}
+
+		flog.Infof("%s received - stopping tftp server", name)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(programArgs.Drain)*time.Second)
+		err := server.Shutdown(ctx)
+		cancel()
+		if err == context.DeadlineExceeded {
+			flog.Errorf("transfers still in progress after %d seconds were abandoned", programArgs.Drain)
+		} else if err != nil {
 			// TODO - we lose the Serve function's error, if any, in this case
 			return err
 		}
@@ -0,0 +1,227 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cli
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+const (
+	// DefaultPort is the well-known TFTP port, used when Client.Server does not name a port
+	DefaultPort = 69
+
+	// DefaultTimeout is how long the client waits for the server before retransmitting, unless Client.Timeout is set
+	DefaultTimeout = 3 * time.Second
+
+	// DefaultRetries is the number of times the client retransmits before abandoning a transfer
+	DefaultRetries = 3
+)
+
+// Client transfers files to and from a TFTP server. Options are only sent to the server when they differ from the
+// RFC 1350 defaults, so a Client with its default settings can talk to servers that predate RFC 2347. A Client holds
+// no connection state, each Get or Put uses its own socket, so a Client may be used by several goroutines at once.
+type Client struct {
+	// Server is the host of the TFTP server with an optional port, e.g. "192.168.1.10" or "tftp.example.com:6969"
+	Server string
+
+	// Mode is the transfer mode, cor.ModeOctet or cor.ModeNetascii
+	Mode string
+
+	// BlockSize is requested with the blksize option (RFC 2348) when it is not cor.BlockSize. The server may agree to a
+	// smaller block size.
+	BlockSize int
+
+	// WindowSize is requested with the windowsize option (RFC 7440) when it is greater than 1. The server may agree to
+	// a smaller window.
+	WindowSize int
+
+	// Timeout is how long to wait for the server before retransmitting, DefaultTimeout if it is 0. When it is a whole
+	// number of seconds from 1 to 255 it is also requested with the timeout option (RFC 2349), so that the server
+	// waits as long for us.
+	Timeout time.Duration
+
+	// Retries is the number of times to retransmit before abandoning a transfer
+	Retries int
+
+	// Rollover is the block number that follows 65535 in a transfer of more than 65535 blocks, 0 or 1. It is requested
+	// with the rollover option when it is 1, or when other options are requested, so that the server numbers blocks
+	// the same way. Block numbers roll over to 0 unless the server acknowledges a rollover of 1.
+	Rollover int
+
+	// TransferSize requests the tsize option (RFC 2349). A Get learns the size of the file before it is sent, and a
+	// Put of known size tells the server how much space it needs. It is not sent in netascii mode.
+	TransferSize bool
+
+	// Progress, if not nil, is called each time a block is received by Get or acknowledged during a Put, with the
+	// number of bytes transferred so far and the size of the file, or -1 if the size is not known
+	Progress func(transferred, size int64)
+}
+
+// NewClient creates a Client for the server at addr, a host with an optional port, that transfers in octet mode
+// without negotiating any options
+func NewClient(addr string) Client {
+	return Client{
+		Server:     addr,
+		Mode:       cor.ModeOctet,
+		BlockSize:  cor.BlockSize,
+		WindowSize: 1,
+		Retries:    DefaultRetries,
+	}
+}
+
+// Get reads the file named filename from the server and writes it to w. It returns the number of bytes received, which
+// in netascii mode counts the bytes on the wire rather than the bytes written to w. If the server refuses the request
+// or sends an error during the transfer, the returned error is a *cor.Err carrying the server's error code.
+// Cancelling ctx abandons the transfer and tells the server so.
+func (c *Client) Get(ctx context.Context, filename string, w io.Writer) (int64, error) {
+	req, err := c.request(cor.OpRRQ, filename, 0)
+
+	if err != nil {
+		return 0, err
+	}
+
+	t, first, err := c.start(ctx, req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer t.close()
+
+	var sink io.WriteCloser = nopCloser{w}
+
+	if t.netascii {
+		sink = cor.NewNetasciiWriter(w)
+	}
+
+	n, err := t.receive(first, sink)
+
+	if err != nil {
+		return n, err
+	}
+
+	return n, sink.Close()
+}
+
+// Put reads r until io.EOF and writes it to the server as the file named filename. size is the number of bytes r will
+// provide, sent to the server with the tsize option if TransferSize is set, or -1 if it is not known. Put returns the
+// number of bytes sent, which in netascii mode counts the bytes on the wire rather than the bytes read from r. Errors
+// are reported as they are by Get.
+func (c *Client) Put(ctx context.Context, filename string, r io.Reader, size int64) (int64, error) {
+	req, err := c.request(cor.OpWRQ, filename, size)
+
+	if err != nil {
+		return 0, err
+	}
+
+	t, first, err := c.start(ctx, req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer t.close()
+
+	if t.netascii {
+		r = cor.NewNetasciiReader(r)
+	}
+
+	if t.size < 0 {
+		t.size = size
+	}
+
+	return t.send(first, r)
+}
+
+// request builds the read or write request for filename, appending the options that differ from the defaults. size is
+// the tsize of a write request, ignored for a read request.
+func (c *Client) request(op cor.OpType, filename string, size int64) (*cor.PacketRequest, error) {
+	req := &cor.PacketRequest{OpCode: op, Filename: filename, Mode: strings.ToLower(c.Mode)}
+
+	if req.Mode == "" {
+		req.Mode = cor.ModeOctet
+	} else if req.Mode != cor.ModeOctet && req.Mode != cor.ModeNetascii {
+		return nil, flog.Raisef("unsupported mode '%s'", c.Mode)
+	}
+
+	if c.BlockSize != 0 && c.BlockSize != cor.BlockSize {
+		if c.BlockSize < cor.MinBlockSize || c.BlockSize > cor.MaxBlockSize {
+			return nil, flog.Raisef("the block size %d is outside the range %d to %d", c.BlockSize, cor.MinBlockSize, cor.MaxBlockSize)
+		}
+
+		req.Options.Set(cor.OptBlockSize, strconv.Itoa(c.BlockSize))
+	}
+
+	if c.WindowSize > 1 {
+		if c.WindowSize > cor.MaxWindowSize {
+			return nil, flog.Raisef("the window size %d is larger than %d", c.WindowSize, cor.MaxWindowSize)
+		}
+
+		req.Options.Set(cor.OptWindowSize, strconv.Itoa(c.WindowSize))
+	}
+
+	if seconds, ok := c.timeoutSeconds(); ok {
+		req.Options.Set(cor.OptTimeout, strconv.Itoa(seconds))
+	}
+
+	if c.TransferSize && req.Mode == cor.ModeOctet {
+		if op == cor.OpRRQ {
+			req.Options.Set(cor.OptTransferSize, "0")
+		} else if size >= 0 {
+			req.Options.Set(cor.OptTransferSize, strconv.FormatInt(size, 10))
+		}
+	}
+
+	if c.Rollover != 0 && c.Rollover != 1 {
+		return nil, flog.Raisef("the rollover %d is not 0 or 1", c.Rollover)
+	}
+
+	// a server that understands options may roll over to 1 unless it is told otherwise
+	if c.Rollover == 1 || len(req.Options) > 0 {
+		req.Options.Set(cor.OptRollover, strconv.Itoa(c.Rollover))
+	}
+
+	return req, nil
+}
+
+// timeoutSeconds returns c.Timeout in seconds and true if it can be requested with the timeout option
+func (c *Client) timeoutSeconds() (int, bool) {
+	if c.Timeout <= 0 || c.Timeout%time.Second != 0 {
+		return 0, false
+	}
+
+	seconds := int(c.Timeout / time.Second)
+	return seconds, seconds >= cor.MinTimeout && seconds <= cor.MaxTimeout
+}
+
+// serverAddr resolves c.Server, adding DefaultPort if it does not name a port
+func (c *Client) serverAddr() (*net.UDPAddr, error) {
+	addr := c.Server
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), strconv.Itoa(DefaultPort))
+	}
+
+	uaddr, err := net.ResolveUDPAddr("udp", addr)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	return uaddr, nil
+}
+
+// nopCloser adds a Close method that does nothing to an io.Writer
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
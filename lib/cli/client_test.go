@@ -0,0 +1,359 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/srv"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// startServer runs a tftp server with a memStore on port, returning the store and a function that stops the server
+func startServer(t *testing.T, port int, files ...cor.File) (stor.Store, func()) {
+	return startConfiguredServer(t, port, nil, files...)
+}
+
+// startConfiguredServer is startServer with configure, if not nil, called before the server starts
+func startConfiguredServer(t *testing.T, port int, configure func(s *srv.Server), files ...cor.File) (stor.Store, func()) {
+	store := stor.NewMemStore()
+
+	for _, f := range files {
+		if err := store.Put(f); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	server := srv.NewServer(store)
+	server.Port = port
+
+	if configure != nil {
+		configure(&server)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if err := server.Serve(); err != nil {
+			flog.Error(err.Error())
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	return store, func() {
+		_ = server.Stop()
+		<-done
+	}
+}
+
+// makeTestData returns size bytes of a repeating pattern
+func makeTestData(size int) []byte {
+	data := make([]byte, size)
+
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return data
+}
+
+func TestRequestOptions(t *testing.T) {
+	c := NewClient("localhost")
+	req, err := c.request(cor.OpRRQ, "file.bin", 0)
+
+	if msg, ok := tcore.TErr("c.request", err); !ok {
+		t.Fatal(msg)
+	}
+
+	// the defaults are those of RFC 1350, so nothing is negotiated
+	if msg, ok := tcore.TAssertInt("len(req.Options)", len(req.Options), 0); !ok {
+		t.Error(msg)
+	}
+
+	c.BlockSize = 1024
+	c.WindowSize = 8
+	c.Timeout = 2 * time.Second
+	c.TransferSize = true
+	req, err = c.request(cor.OpWRQ, "file.bin", 5000)
+
+	if msg, ok := tcore.TErr("c.request", err); !ok {
+		t.Fatal(msg)
+	}
+
+	want := map[string]string{
+		cor.OptBlockSize:    "1024",
+		cor.OptWindowSize:   "8",
+		cor.OptTimeout:      "2",
+		cor.OptTransferSize: "5000",
+	}
+
+	for name, value := range want {
+		got, _ := req.Options.Get(name)
+
+		if msg, ok := tcore.TAssertString(name, got, value); !ok {
+			t.Error(msg)
+		}
+	}
+
+	// a timeout that is not a whole number of seconds is only used by the client
+	c.Timeout = 1500 * time.Millisecond
+	req, _ = c.request(cor.OpRRQ, "file.bin", 0)
+
+	if _, ok := req.Options.Get(cor.OptTimeout); ok {
+		t.Error("a fractional timeout should not be requested")
+	}
+
+	// the rollover is pinned once options are being negotiated, and a rollover of 1 is always requested
+	got, _ := req.Options.Get(cor.OptRollover)
+
+	if msg, ok := tcore.TAssertString(cor.OptRollover, got, "0"); !ok {
+		t.Error(msg)
+	}
+
+	c = NewClient("localhost")
+	c.Rollover = 1
+	req, _ = c.request(cor.OpRRQ, "file.bin", 0)
+	got, _ = req.Options.Get(cor.OptRollover)
+
+	if msg, ok := tcore.TAssertString(cor.OptRollover, got, "1"); !ok {
+		t.Error(msg)
+	}
+
+	c.Rollover = 2
+
+	if _, err = c.request(cor.OpRRQ, "file.bin", 0); err == nil {
+		t.Error("a rollover of 2 should be refused")
+	}
+
+	c.Rollover = 0
+	c.Mode = cor.ModeMail
+
+	if _, err = c.request(cor.OpRRQ, "file.bin", 0); err == nil {
+		t.Error("mail mode should not be supported")
+	}
+}
+
+func TestPutGet(t *testing.T) {
+	_, stop := startServer(t, 11201)
+	defer stop()
+
+	testFile := makeTestData(10*1024 + 17)
+	c := NewClient("127.0.0.1:11201")
+	c.BlockSize = 1024
+	c.WindowSize = 4
+	c.TransferSize = true
+	var lastTransferred, lastSize int64
+	c.Progress = func(transferred, size int64) {
+		lastTransferred = transferred
+		lastSize = size
+	}
+
+	n, err := c.Put(context.Background(), "put-get.bin", bytes.NewReader(testFile), int64(len(testFile)))
+
+	if msg, ok := tcore.TErr("c.Put", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("put bytes", int(n), len(testFile)); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("put progress", int(lastTransferred), len(testFile)); !ok {
+		t.Error(msg)
+	}
+
+	got := bytes.Buffer{}
+	lastTransferred, lastSize = 0, 0
+	n, err = c.Get(context.Background(), "put-get.bin", &got)
+
+	if msg, ok := tcore.TErr("c.Get", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("get bytes", int(n), len(testFile)); !ok {
+		t.Error(msg)
+	}
+
+	if !bytes.Equal(got.Bytes(), testFile) {
+		t.Error("the file received is not the file sent")
+	}
+
+	// the server announced the size with tsize
+	if msg, ok := tcore.TAssertInt("get progress size", int(lastSize), len(testFile)); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("get progress", int(lastTransferred), len(testFile)); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestPutGetRollover(t *testing.T) {
+	_, stop := startConfiguredServer(t, 11204, func(s *srv.Server) { s.Rollover = 1 })
+	defer stop()
+
+	// more than 65535 blocks, so that block numbers roll over
+	testFile := makeTestData(8*(math.MaxUint16+100) + 3)
+
+	for _, rollover := range []int{0, 1} {
+		c := NewClient("127.0.0.1:11204")
+		c.BlockSize = 8
+		c.WindowSize = 16
+		c.Rollover = rollover
+		name := fmt.Sprintf("rollover-%d.bin", rollover)
+
+		if _, err := c.Put(context.Background(), name, bytes.NewReader(testFile), -1); err != nil {
+			t.Errorf("rollover %d: c.Put: %s", rollover, err.Error())
+			continue
+		}
+
+		got := bytes.Buffer{}
+
+		if _, err := c.Get(context.Background(), name, &got); err != nil {
+			t.Errorf("rollover %d: c.Get: %s", rollover, err.Error())
+		} else if !bytes.Equal(got.Bytes(), testFile) {
+			t.Errorf("rollover %d: the file received is not the file sent", rollover)
+		}
+	}
+}
+
+func TestPutGetNetascii(t *testing.T) {
+	store, stop := startServer(t, 11202)
+	defer stop()
+
+	local := []byte("first line\nsecond\rline\n")
+	c := NewClient("127.0.0.1:11202")
+	c.Mode = cor.ModeNetascii
+
+	if _, err := c.Put(context.Background(), "netascii.txt", bytes.NewReader(local), -1); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the client encodes and the server decodes, so the server holds the file as it was
+	stored, err := store.Get("netascii.txt")
+
+	if msg, ok := tcore.TErr("store.Get", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertString("stored", string(stored.Data), string(local)); !ok {
+		t.Error(msg)
+	}
+
+	got := bytes.Buffer{}
+	n, err := c.Get(context.Background(), "netascii.txt", &got)
+
+	if msg, ok := tcore.TErr("c.Get", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertString("received", got.String(), string(local)); !ok {
+		t.Error(msg)
+	}
+
+	// two line endings and a carriage return each take an extra byte on the wire
+	if msg, ok := tcore.TAssertInt("bytes on the wire", int(n), len(local)+3); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetNotFound(t *testing.T) {
+	_, stop := startServer(t, 11203)
+	defer stop()
+
+	c := NewClient("127.0.0.1:11203")
+	_, err := c.Get(context.Background(), "does-not-exist.bin", &bytes.Buffer{})
+	e, ok := err.(*cor.Err)
+
+	if !ok {
+		t.Fatalf("want a *cor.Err, got %v", err)
+	}
+
+	if msg, ok := tcore.TAssertInt("e.Code()", int(e.Code()), int(cor.ErrNotFound)); !ok {
+		t.Error(msg)
+	}
+}
+
+// listenSilently opens a socket that never answers, returning its address and a function that counts the packets
+// received so far
+func listenSilently(t *testing.T) (string, func() int, func()) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	received := make(chan struct{}, 100)
+
+	go func() {
+		buf := make([]byte, cor.MaxPacketSize)
+
+		for {
+			if _, _, err := conn.ReadFromUDP(buf); err != nil {
+				return
+			}
+
+			received <- struct{}{}
+		}
+	}()
+
+	count := func() int {
+		return len(received)
+	}
+
+	return fmt.Sprintf("127.0.0.1:%d", conn.LocalAddr().(*net.UDPAddr).Port), count, func() { _ = conn.Close() }
+}
+
+func TestGetNoResponse(t *testing.T) {
+	addr, count, closer := listenSilently(t)
+	defer closer()
+
+	c := NewClient(addr)
+	c.Timeout = 50 * time.Millisecond
+	c.Retries = 2
+
+	if _, err := c.Get(context.Background(), "file.bin", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected an error when the server does not respond")
+	}
+
+	// the request is sent once and then retried twice
+	if msg, ok := tcore.TAssertInt("requests sent", count(), 3); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetCancel(t *testing.T) {
+	addr, _, closer := listenSilently(t)
+	defer closer()
+
+	c := NewClient(addr)
+	c.Timeout = 10 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := c.Get(ctx, "file.bin", &bytes.Buffer{})
+
+	if err != context.Canceled {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the cancelled transfer took %s to return", elapsed.String())
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cli
+
+import (
+	"io"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// receive writes the blocks sent by the server to w until the final block, the first one shorter than the block size,
+// has arrived. first is the server's response to the request, either an OACK or the first DATA packet. Blocks are
+// acknowledged once per window (RFC 7440), and block numbers roll over after 65535 as negotiated. It returns the number
+// of bytes received.
+func (t *transfer) receive(first cor.Packet, w io.Writer) (int64, error) {
+	var numBytes int64
+
+	// blk is the next block we expect, counted from 1 without rolling over
+	var blk uint64 = 1
+
+	// the number of blocks received since the last acknowledgement
+	unacked := 0
+
+	// true when we have told the server about a lost block and are waiting for it to resend the window
+	lossReported := false
+
+	// the number of consecutive times the server failed to respond in time
+	timeouts := 0
+
+	packet := first
+
+	if first.IsOAck() {
+		// the OACK is acknowledged with block 0, then data begins
+		if err := t.write(&cor.PacketAck{BlockNum: 0}); err != nil {
+			return 0, t.fail(err)
+		}
+
+		packet = nil
+	}
+
+	for {
+		if packet == nil {
+			var err error
+			packet, err = t.read()
+
+			if err == errTimeout {
+				timeouts++
+
+				if timeouts > t.retries {
+					return numBytes, t.fail(cor.NewErrf(cor.ErrUnknown, "block %d was not received after %d retries", blk, t.retries))
+				}
+
+				// our last acknowledgement may have been lost, sending it again prompts the server to resend
+				if err = t.write(&cor.PacketAck{BlockNum: t.wireBlock(blk - 1)}); err != nil {
+					return numBytes, t.fail(err)
+				}
+
+				continue
+			} else if err != nil {
+				return numBytes, t.fail(err)
+			}
+		}
+
+		data, ok := packet.(*cor.PacketData)
+		packet = nil
+
+		if !ok {
+			if first.IsOAck() && blk == 1 {
+				// the server resent its OACK, so our acknowledgement of it was lost
+				timeouts = 0
+				_ = t.write(&cor.PacketAck{BlockNum: 0})
+				continue
+			}
+
+			return numBytes, t.fail(cor.NewErr(cor.ErrBadOp, "expected a data packet"))
+		}
+
+		if data.BlockNum != t.wireBlock(blk) {
+			// a duplicate means our acknowledgement was lost, a block from further ahead means a block in the window
+			// was lost. either way, acknowledging the last block we have tells the server where to resume
+			if t.windowSize == 1 || !lossReported {
+				lossReported = true
+				unacked = 0
+
+				if err := t.write(&cor.PacketAck{BlockNum: t.wireBlock(blk - 1)}); err != nil {
+					return numBytes, t.fail(err)
+				}
+			}
+
+			continue
+		}
+
+		timeouts = 0
+		lossReported = false
+		unacked++
+
+		if _, err := w.Write(data.Data); err != nil {
+			return numBytes, t.fail(cor.NewErrf(cor.ErrDisk, "the file could not be written: %s", err.Error()))
+		}
+
+		numBytes += int64(len(data.Data))
+		t.report(numBytes)
+		isLast := len(data.Data) < t.blockSize
+
+		if isLast || unacked >= t.windowSize {
+			unacked = 0
+
+			if err := t.write(&cor.PacketAck{BlockNum: t.wireBlock(blk)}); err != nil {
+				return numBytes, t.fail(flog.Raisef("acknowledgement could not be sent %s", err.Error()))
+			}
+		}
+
+		if isLast {
+			return numBytes, nil
+		}
+
+		blk++
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cli
+
+import (
+	"io"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// send streams src to the server, returning when the final block has been acknowledged. first is the server's
+// response to the request, either an OACK or the acknowledgement of block 0. Up to windowSize blocks are sent before
+// waiting for an acknowledgement (RFC 7440). Unacknowledged blocks are kept so that they can be sent again when the
+// server reports a loss or stops responding. Block numbers roll over after 65535 as negotiated. It returns the number
+// of bytes sent.
+func (t *transfer) send(first cor.Packet, src io.Reader) (int64, error) {
+	if ack, ok := first.(*cor.PacketAck); !first.IsOAck() && (!ok || ack.BlockNum != 0) {
+		return 0, t.fail(cor.NewErr(cor.ErrBadOp, "expected an acknowledgement of the write request"))
+	}
+
+	s := sender{transfer: t, window: cor.NewWindow(src, t.blockSize, t.windowSize, t.wireBlock)}
+
+	if err := s.run(); err != nil {
+		return s.acked, t.fail(err)
+	}
+
+	return s.acked, nil
+}
+
+// sender holds the window of a Put
+type sender struct {
+	*transfer
+	window *cor.Window // the blocks that have not been acknowledged
+	acked  int64       // the number of bytes the server has acknowledged
+}
+
+// run sends the whole of src. When the server does not respond in time, the unacknowledged blocks are sent again, up
+// to the transfer's retry limit.
+func (s *sender) run() error {
+	timeouts := 0
+
+	for {
+		if err := s.window.Fill(); err != nil {
+			return flog.Wrap(err)
+		}
+
+		if s.window.Done() {
+			return nil
+		}
+
+		for blk, block, ok := s.window.Next(); ok; blk, block, ok = s.window.Next() {
+			if err := s.write(&cor.PacketData{BlockNum: blk, Data: block}); err != nil {
+				return err
+			}
+		}
+
+		ackNum, err := s.readAck()
+
+		if err == errTimeout {
+			timeouts++
+
+			if timeouts > s.retries {
+				return cor.NewErrf(cor.ErrUnknown, "block %d was not acknowledged after %d retries", s.window.Base(), s.retries)
+			}
+
+			s.window.Rewind()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if n, ok := s.window.Ack(ackNum); ok {
+			timeouts = 0
+			s.acked += n
+			s.report(s.acked)
+		}
+	}
+}
+
+// readAck waits for an acknowledgement from the server. A repeated OACK is treated as an acknowledgement of block 0.
+func (s *sender) readAck() (uint16, error) {
+	packet, err := s.read()
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch p := packet.(type) {
+	case *cor.PacketAck:
+		return p.BlockNum, nil
+	case *cor.PacketOAck:
+		return 0, nil
+	}
+
+	return 0, cor.NewErrf(cor.ErrBadOp, "expected an acknowledgement, got %s", packet.Op().String())
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// errTimeout is returned by read when the server does not respond in time
+var errTimeout = errors.New("timed out waiting for the server")
+
+// transfer is the state of a single Get or Put
+type transfer struct {
+	ctx        context.Context
+	conn       *net.UDPConn
+	server     *net.UDPAddr  // the server's well-known address, where the request is sent
+	peer       *net.UDPAddr  // the address the server chose for the transfer, nil until it first responds
+	peerFailed bool          // true once the server has sent an error, which must not be answered
+	buf        []byte        // receives packets from the server
+	blockSize  int           // cor.BlockSize unless the server acknowledged blksize
+	windowSize int           // 1 unless the server acknowledged windowsize
+	rollover   uint16        // the block number that follows 65535, 0 unless the server acknowledged a rollover of 1
+	timeout    time.Duration // how long to wait for the server before retransmitting
+	retries    int           // how many times to retransmit before giving up
+	netascii   bool          // true if the transfer is in netascii mode
+	size       int64         // the size of the file, if known from tsize, otherwise -1
+	progress   func(transferred, size int64)
+	done       chan struct{} // closed when the transfer ends, stopping the goroutine that watches ctx
+}
+
+// start sends req to the server, sending it again if the server does not respond in time, and returns the transfer
+// along with the server's first response. If the response is an OACK the acknowledged options are applied to the
+// transfer, and refused with an ErrOption error if the server answered with something that was not asked for.
+func (c *Client) start(ctx context.Context, req *cor.PacketRequest) (*transfer, cor.Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	server, err := c.serverAddr()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+
+	if err != nil {
+		return nil, nil, flog.Wrap(err)
+	}
+
+	// the buffer must hold a DATA packet of the largest block size the server could agree to
+	bufSize := cor.MaxPacketSize
+
+	if c.BlockSize+4 > bufSize {
+		bufSize = c.BlockSize + 4
+	}
+
+	t := &transfer{
+		ctx:        ctx,
+		conn:       conn,
+		server:     server,
+		buf:        make([]byte, bufSize),
+		blockSize:  cor.BlockSize,
+		windowSize: 1,
+		timeout:    c.Timeout,
+		retries:    c.Retries,
+		netascii:   req.Mode == cor.ModeNetascii,
+		size:       -1,
+		progress:   c.Progress,
+		done:       make(chan struct{}),
+	}
+
+	if t.timeout <= 0 {
+		t.timeout = DefaultTimeout
+	}
+
+	if t.retries < 0 {
+		t.retries = 0
+	}
+
+	go t.watch()
+	reqBytes := req.Serialize()
+
+	for attempt := 0; ; attempt++ {
+		if _, err = conn.WriteToUDP(reqBytes, server); err != nil {
+			t.close()
+			return nil, nil, flog.Wrap(err)
+		}
+
+		packet, err := t.read()
+
+		if err == errTimeout {
+			if attempt < t.retries {
+				continue
+			}
+
+			err = flog.Raisef("no response from %s after %d retries", server.String(), t.retries)
+		}
+
+		if err != nil {
+			t.close()
+			return nil, nil, err
+		}
+
+		if oack, ok := packet.(*cor.PacketOAck); ok {
+			if err = t.accept(oack, req.Options); err != nil {
+				err = t.fail(err)
+				t.close()
+				return nil, nil, err
+			}
+		}
+
+		return t, packet, nil
+	}
+}
+
+// accept applies the options acknowledged by the server. The server may only acknowledge options that were requested,
+// and may only lower blksize and windowsize (RFC 2347).
+func (t *transfer) accept(oack *cor.PacketOAck, requested cor.Options) error {
+	for _, opt := range oack.Options {
+		want, ok := requested.Get(opt.Name)
+
+		if !ok {
+			return cor.NewErrf(cor.ErrOption, "the server acknowledged '%s', which was not requested", opt.Name)
+		}
+
+		value, err := strconv.ParseInt(opt.Value, 10, 64)
+
+		if err != nil {
+			return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+		}
+
+		wanted, _ := strconv.ParseInt(want, 10, 64)
+
+		switch strings.ToLower(opt.Name) {
+		case cor.OptBlockSize:
+			if value < cor.MinBlockSize || value > wanted {
+				return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			t.blockSize = int(value)
+		case cor.OptWindowSize:
+			if value < cor.MinWindowSize || value > wanted {
+				return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			t.windowSize = int(value)
+		case cor.OptTimeout:
+			// the timeout is not negotiable, the server must accept it as given or leave it out
+			if value != wanted {
+				return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+			}
+		case cor.OptTransferSize:
+			if value < 0 {
+				return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			t.size = value
+		case cor.OptRollover:
+			if value != 0 && value != 1 {
+				return cor.NewErrf(cor.ErrOption, "the server acknowledged an invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			t.rollover = uint16(value)
+		}
+	}
+
+	return nil
+}
+
+// wireBlock converts a block count, which starts at 1 and never rolls over, to the 16 bit block number that is sent on
+// the wire. Block numbers roll over after 65535 to the transfer's rollover.
+func (t *transfer) wireBlock(blk uint64) uint16 {
+	if t.rollover == 0 || blk <= math.MaxUint16 {
+		return uint16(blk)
+	}
+
+	return uint16((blk-1)%math.MaxUint16 + 1)
+}
+
+// read waits up to the transfer's timeout for a packet from the server, returning errTimeout if none arrives. Packets
+// from other addresses are answered with an ErrBadID error and otherwise ignored. An ERROR packet from the server is
+// returned as a *cor.Err, and if ctx is cancelled its error is returned.
+func (t *transfer) read() (cor.Packet, error) {
+	if err := t.conn.SetReadDeadline(time.Now().Add(t.timeout)); err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	// ctx may have been cancelled before the deadline above replaced the one set by watch
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	for {
+		n, addr, err := t.conn.ReadFromUDP(t.buf)
+
+		if err != nil {
+			if ctxErr := t.ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, errTimeout
+			}
+
+			return nil, flog.Wrap(err)
+		}
+
+		// the server answers the request from a new port, which identifies the transfer from then on (RFC 1350)
+		if t.peer == nil && addr.IP.Equal(t.server.IP) {
+			t.peer = addr
+		}
+
+		if t.peer == nil || !addr.IP.Equal(t.peer.IP) || addr.Port != t.peer.Port {
+			t.sendErr(addr, cor.ErrBadID, "unknown transfer id")
+			continue
+		}
+
+		packet, err := cor.ParsePacket(t.buf[:n])
+
+		if err != nil {
+			return nil, flog.Wrap(err)
+		}
+
+		if pktErr, ok := packet.(*cor.PacketError); ok {
+			t.peerFailed = true
+			return nil, cor.NewErr(pktErr.Code, pktErr.Msg)
+		}
+
+		return packet, nil
+	}
+}
+
+// write sends p to the server
+func (t *transfer) write(p cor.Packet) error {
+	if _, err := t.conn.WriteToUDP(p.Serialize(), t.peer); err != nil {
+		return flog.Wrap(err)
+	}
+
+	return nil
+}
+
+// fail tells the server that the transfer is abandoned because of err, unless the server sent err, and returns err
+func (t *transfer) fail(err error) error {
+	if t.peer == nil || t.peerFailed {
+		return err
+	}
+
+	code := cor.ErrUnknown
+
+	if e, ok := err.(*cor.Err); ok {
+		code = e.Code()
+	}
+
+	msg := err.Error()
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		msg = "the transfer was cancelled"
+	}
+
+	t.sendErr(t.peer, code, msg)
+	return err
+}
+
+// sendErr sends an ERROR packet to addr. Errors are not acknowledged, so its delivery is not checked.
+func (t *transfer) sendErr(addr *net.UDPAddr, code cor.ErrCode, msg string) {
+	p := cor.PacketError{Code: code, Msg: msg}
+
+	if _, err := t.conn.WriteToUDP(p.Serialize(), addr); err != nil {
+		flog.Trace(err.Error())
+	}
+}
+
+// report calls the progress function, if there is one
+func (t *transfer) report(transferred int64) {
+	if t.progress != nil {
+		t.progress(transferred, t.size)
+	}
+}
+
+// watch wakes a read in progress when ctx is cancelled. It returns when ctx is cancelled or the transfer is closed.
+func (t *transfer) watch() {
+	select {
+	case <-t.ctx.Done():
+		_ = t.conn.SetReadDeadline(time.Unix(1, 0))
+	case <-t.done:
+	}
+}
+
+// close releases the transfer's socket
+func (t *transfer) close() {
+	close(t.done)
+	_ = t.conn.Close()
+}
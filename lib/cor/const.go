@@ -7,9 +7,34 @@ package cor
 const (
 	// BlockSize is the standard chunk size for TFTP transfers
 	BlockSize = 512
+
+	// MinBlockSize is the smallest block size a client may negotiate with the blksize option (RFC 2348)
+	MinBlockSize = 8
+
+	// MaxBlockSize is the largest block size a client may negotiate with the blksize option (RFC 2348)
+	MaxBlockSize = 65464
+
+	// MinTimeout is the smallest number of seconds a client may negotiate with the timeout option (RFC 2349)
+	MinTimeout = 1
+
+	// MaxTimeout is the largest number of seconds a client may negotiate with the timeout option (RFC 2349)
+	MaxTimeout = 255
+
+	// MinWindowSize is the smallest number of blocks a client may negotiate with the windowsize option (RFC 7440)
+	MinWindowSize = 1
+
+	// MaxWindowSize is the largest number of blocks a client may negotiate with the windowsize option (RFC 7440)
+	MaxWindowSize = 65535
+)
+
+// Transfer modes given by RFC 1350. Modes are case-insensitive.
+const (
+	ModeNetascii = "netascii"
+	ModeOctet    = "octet"
+	ModeMail     = "mail"
 )
 
-// ErrCode represents the error codes given by RFC 1350
+// ErrCode represents the error codes given by RFC 1350 and RFC 2347
 type ErrCode uint16
 
 const (
@@ -21,6 +46,7 @@ const (
 	ErrBadID            = 5 // Unknown transfer ID.
 	ErrDupFile          = 6 // File already exists.
 	ErrUnkUser          = 7 // No such user.
+	ErrOption           = 8 // Option negotiation failed (RFC 2347).
 )
 
 func (e ErrCode) String() string {
@@ -41,6 +67,8 @@ func (e ErrCode) String() string {
 		return "E_DUP_FILE"
 	case ErrUnkUser:
 		return "E_UNK_USER"
+	case ErrOption:
+		return "E_OPTION"
 	default:
 		break
 	}
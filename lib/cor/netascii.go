@@ -0,0 +1,122 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"io"
+)
+
+// netascii is the 8 bit ascii of RFC 764 with line endings transmitted as CR LF, and a carriage return that is not
+// part of a line ending transmitted as CR NUL. Locally, line endings are a lone LF.
+
+// netasciiChunk is the number of bytes read from the source of a netascii encoding at a time
+const netasciiChunk = 4096
+
+// netasciiReader encodes the bytes read from r into netascii
+type netasciiReader struct {
+	r       io.Reader
+	in      []byte // the bytes most recently read from r
+	out     []byte // encoded bytes that have not yet been returned to the caller
+	encoded []byte // backing storage for out
+	err     error  // the error returned by r, reported once out is drained
+}
+
+// NewNetasciiReader returns a reader that encodes the bytes read from r into netascii. An encoded pair is never lost
+// when it straddles two calls to Read, so the result can be chunked into blocks of any size.
+func NewNetasciiReader(r io.Reader) io.Reader {
+	return &netasciiReader{
+		r:       r,
+		in:      make([]byte, netasciiChunk),
+		encoded: make([]byte, 0, 2*netasciiChunk),
+	}
+}
+
+// Read implements io.Reader
+func (n *netasciiReader) Read(p []byte) (int, error) {
+	for len(n.out) == 0 {
+		if n.err != nil {
+			return 0, n.err
+		}
+
+		m, err := n.r.Read(n.in)
+		n.err = err
+		n.out = n.encoded[:0]
+
+		for _, c := range n.in[:m] {
+			switch c {
+			case '\n':
+				n.out = append(n.out, '\r', '\n')
+			case '\r':
+				n.out = append(n.out, '\r', 0)
+			default:
+				n.out = append(n.out, c)
+			}
+		}
+	}
+
+	copied := copy(p, n.out)
+	n.out = n.out[copied:]
+	return copied, nil
+}
+
+// netasciiWriter decodes netascii written to it and writes the result to w
+type netasciiWriter struct {
+	w   io.Writer
+	cr  bool   // true when the last byte written was a CR, whose meaning depends on the byte that follows it
+	out []byte // reused for the decoded bytes of each write
+}
+
+// NewNetasciiWriter returns a writer that decodes netascii written to it and writes the result to w. A CR at the end
+// of one write is held until the next write shows whether it begins a line ending, so netascii can be written one
+// block at a time. Close flushes a CR held at the end of the stream, it does not close w.
+func NewNetasciiWriter(w io.Writer) io.WriteCloser {
+	return &netasciiWriter{w: w}
+}
+
+// Write implements io.Writer
+func (n *netasciiWriter) Write(p []byte) (int, error) {
+	out := n.out[:0]
+
+	for _, c := range p {
+		if n.cr {
+			n.cr = false
+
+			if c == '\n' {
+				out = append(out, '\n')
+				continue
+			} else if c == 0 {
+				out = append(out, '\r')
+				continue
+			}
+
+			// a bare CR is not valid netascii, but it is passed through rather than lost
+			out = append(out, '\r')
+		}
+
+		if c == '\r' {
+			n.cr = true
+			continue
+		}
+
+		out = append(out, c)
+	}
+
+	n.out = out
+
+	if _, err := n.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close writes a CR held from the final write, it does not close the underlying writer
+func (n *netasciiWriter) Close() error {
+	if !n.cr {
+		return nil
+	}
+
+	n.cr = false
+	_, err := n.w.Write([]byte{'\r'})
+	return err
+}
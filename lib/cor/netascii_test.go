@@ -0,0 +1,88 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/webern/tcore"
+)
+
+var netasciiTests = []struct {
+	local    string
+	netascii string
+}{
+	{"", ""},
+	{"hello", "hello"},
+	{"line one\nline two\n", "line one\r\nline two\r\n"},
+	{"\n\n", "\r\n\r\n"},
+	{"carriage\rreturn", "carriage\r\x00return"},
+	{"\r\n", "\r\x00\r\n"},
+	{"ends with cr\r", "ends with cr\r\x00"},
+}
+
+func TestNetasciiReader(t *testing.T) {
+	for _, test := range netasciiTests {
+		// reading one byte at a time forces every encoded pair to straddle two reads
+		got, err := ioutil.ReadAll(iotest.OneByteReader(NewNetasciiReader(bytes.NewReader([]byte(test.local)))))
+
+		if msg, ok := tcore.TErr("ioutil.ReadAll", err); !ok {
+			t.Error(msg)
+		}
+
+		if msg, ok := tcore.TAssertString("encoded", string(got), test.netascii); !ok {
+			t.Error(msg)
+		}
+	}
+}
+
+func TestNetasciiWriter(t *testing.T) {
+	for _, test := range netasciiTests {
+		// write one byte at a time so that every CR is split from the byte that follows it
+		for _, chunkSize := range []int{1, 2, 512} {
+			decoded := bytes.Buffer{}
+			w := NewNetasciiWriter(&decoded)
+			in := []byte(test.netascii)
+
+			for pos := 0; pos < len(in); pos += chunkSize {
+				end := pos + chunkSize
+
+				if end > len(in) {
+					end = len(in)
+				}
+
+				if _, err := w.Write(in[pos:end]); err != nil {
+					t.Error(err.Error())
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Error(err.Error())
+			}
+
+			if msg, ok := tcore.TAssertString("decoded", decoded.String(), test.local); !ok {
+				t.Error(msg)
+			}
+		}
+	}
+}
+
+func TestNetasciiWriterTrailingCR(t *testing.T) {
+	// a bare CR at the very end is held until Close
+	decoded := bytes.Buffer{}
+	w := NewNetasciiWriter(&decoded)
+	_, _ = w.Write([]byte("abc\r"))
+
+	if msg, ok := tcore.TAssertString("before Close", decoded.String(), "abc"); !ok {
+		t.Error(msg)
+	}
+
+	_ = w.Close()
+
+	if msg, ok := tcore.TAssertString("after Close", decoded.String(), "abc\r"); !ok {
+		t.Error(msg)
+	}
+}
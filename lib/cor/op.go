@@ -10,6 +10,7 @@ const (
 	OpData         = 3 // Data Packet
 	OpAck          = 4 // Acknowledgement
 	OpError        = 5 // Error Packet
+	OpOAck         = 6 // Option Acknowledgement (RFC 2347)
 )
 
 func (o OpType) String() string {
@@ -24,6 +25,8 @@ func (o OpType) String() string {
 		return "ACKN"
 	case OpError:
 		return "ERRO"
+	case OpOAck:
+		return "OACK"
 	default:
 		break
 	}
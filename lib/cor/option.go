@@ -0,0 +1,110 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/webern/flog"
+)
+
+// https://tools.ietf.org/html/rfc2347
+
+const (
+	// OptBlockSize negotiates the number of data bytes per DATA packet (RFC 2348)
+	OptBlockSize = "blksize"
+
+	// OptTransferSize communicates the size of the file in bytes before the transfer begins (RFC 2349)
+	OptTransferSize = "tsize"
+
+	// OptTimeout negotiates the number of seconds to wait before retransmitting (RFC 2349)
+	OptTimeout = "timeout"
+
+	// OptWindowSize negotiates the number of blocks sent before waiting for an acknowledgement (RFC 7440)
+	OptWindowSize = "windowsize"
+
+	// OptRollover chooses whether block numbers roll over to 0 or 1 after 65535. It is not part of any RFC, but
+	// tftp-hpa and some clients use it.
+	OptRollover = "rollover"
+)
+
+// Option is a single name/value pair appended to a request, or echoed back in an OACK, per RFC 2347
+type Option struct {
+	Name  string
+	Value string
+}
+
+// Options is an ordered list of options. The order is preserved when parsing and serializing. Option names are
+// case-insensitive, so lookups ignore case.
+type Options []Option
+
+// Get returns the value of the named option and true, or an empty string and false if the option is not present
+func (o Options) Get(name string) (string, bool) {
+	for _, opt := range o {
+		if strings.EqualFold(opt.Name, name) {
+			return opt.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Set replaces the value of the named option, or appends the option if it is not present
+func (o *Options) Set(name, value string) {
+	for i := range *o {
+		if strings.EqualFold((*o)[i].Name, name) {
+			(*o)[i].Value = value
+			return
+		}
+	}
+
+	*o = append(*o, Option{Name: name, Value: value})
+}
+
+// size returns the number of bytes needed to serialize the options
+func (o Options) size() int {
+	n := 0
+
+	for _, opt := range o {
+		n += len(opt.Name) + 1 + len(opt.Value) + 1
+	}
+
+	return n
+}
+
+// serialize writes each option as a null-terminated name followed by a null-terminated value into buf, which must be
+// at least o.size() bytes long
+func (o Options) serialize(buf []byte) {
+	pos := 0
+
+	for _, opt := range o {
+		pos += copy(buf[pos:], opt.Name) + 1
+		pos += copy(buf[pos:], opt.Value) + 1
+	}
+}
+
+// parseOptions reads null-terminated name/value pairs until buf is exhausted. It returns nil if buf is empty. An empty
+// option name ends the list since some clients pad their requests with null bytes, and so does an option that is cut
+// short without a value, which is ignored as a server that does not understand options would ignore it.
+func parseOptions(buf []byte) (Options, error) {
+	var opts Options
+
+	for len(buf) > 0 {
+		opt := Option{}
+		var err error
+
+		if opt.Name, buf, err = parseString(buf); err != nil || len(opt.Name) == 0 {
+			break
+		}
+
+		if opt.Value, buf, err = parseString(buf); err != nil {
+			flog.Trace(fmt.Sprintf("ignoring option '%s', it has no value", opt.Name))
+			break
+		}
+
+		opts = append(opts, opt)
+	}
+
+	return opts, nil
+}
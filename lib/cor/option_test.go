@@ -0,0 +1,84 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"testing"
+
+	"github.com/webern/tcore"
+)
+
+func TestOptionsGetSet(t *testing.T) {
+	opts := Options{}
+	opts.Set("blksize", "1024")
+	opts.Set("tsize", "0")
+	opts.Set("BLKSIZE", "512")
+
+	stm := "len(opts)"
+	gotI := len(opts)
+	wantI := 2
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+
+	val, found := opts.Get("BlkSize")
+
+	if msg, ok := tcore.TAssertBool("found", found, true); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertString("val", val, "512"); !ok {
+		t.Error(msg)
+	}
+
+	// the order of insertion is preserved
+	if msg, ok := tcore.TAssertString("opts[1].Name", opts[1].Name, "tsize"); !ok {
+		t.Error(msg)
+	}
+
+	_, found = opts.Get("windowsize")
+
+	if msg, ok := tcore.TAssertBool("found", found, false); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestParseOptionsPadding(t *testing.T) {
+	// trailing null bytes after the mode are tolerated
+	p := PacketRequest{}
+	err := p.Parse([]byte("\x00\x01foo\x00octet\x00tsize\x000\x00\x00\x00\x00"))
+
+	if msg, ok := tcore.TErr("p.Parse", err); !ok {
+		t.Error(msg)
+		return
+	}
+
+	stm := "len(p.Options)"
+	gotI := len(p.Options)
+	wantI := 1
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestParseOptionsTruncated(t *testing.T) {
+	// an option cut short ends the list, the request and the options before it are kept
+	requests := []string{
+		"\x00\x01foo\x00octet\x00tsize\x000\x00blksize\x00",
+		"\x00\x01foo\x00octet\x00tsize\x000\x00blksize\x001428",
+		"\x00\x01foo\x00octet\x00tsize\x000\x00blksi",
+	}
+
+	for _, request := range requests {
+		p := PacketRequest{}
+
+		if err := p.Parse([]byte(request)); err != nil {
+			t.Errorf("Parsing packet %q: %s", request, err.Error())
+			continue
+		}
+
+		if msg, ok := tcore.TAssertInt("len(p.Options)", len(p.Options), 1); !ok {
+			t.Errorf("Parsing packet %q: %s", request, msg)
+		}
+	}
+}
@@ -10,7 +10,8 @@ import (
 )
 
 // MaxPacketSize represents the maximum expected UDP packet size. Larger than a typical mtu (1500), and largest DATA
-// packet (516). may limit the length of filenames in RRQ/WRQs -- RFC1350 doesn't offer a bound for these.
+// packet (516). may limit the length of filenames in RRQ/WRQs -- RFC1350 doesn't offer a bound for these. DATA packets
+// can exceed this when a larger block size is negotiated (RFC 2348), so size receive buffers for the block size.
 const MaxPacketSize = 2048
 
 // Packet is the interface met by all packet structs
@@ -38,6 +39,9 @@ type Packet interface {
 
 	// IsError is for convenience, returns true if the packet is an error packet
 	IsError() bool
+
+	// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+	IsOAck() bool
 }
 
 // PacketRequest represents a request to read or rite a file.
@@ -45,6 +49,7 @@ type PacketRequest struct {
 	OpCode   OpType // OpRRQ or OpWRQ
 	Filename string
 	Mode     string
+	Options  Options // RFC 2347 options appended after the mode, nil if there are none
 }
 
 // Op returns the OpType code for this packet
@@ -68,7 +73,11 @@ func (p *PacketRequest) Parse(buf []byte) (err error) {
 		return err
 	}
 
-	if p.Mode, _, err = parseString(buf3); err != nil {
+	if p.Mode, buf3, err = parseString(buf3); err != nil {
+		return err
+	}
+
+	if p.Options, err = parseOptions(buf3); err != nil {
 		return err
 	}
 
@@ -77,10 +86,12 @@ func (p *PacketRequest) Parse(buf []byte) (err error) {
 
 // Serialize serializes a packet to its wire representation
 func (p *PacketRequest) Serialize() []byte {
-	buf := make([]byte, 2+len(p.Filename)+1+len(p.Mode)+1)
+	headerLen := 2 + len(p.Filename) + 1 + len(p.Mode) + 1
+	buf := make([]byte, headerLen+p.Options.size())
 	binary.BigEndian.PutUint16(buf, uint16(p.OpCode))
 	copy(buf[2:], p.Filename)
 	copy(buf[2+len(p.Filename)+1:], p.Mode)
+	p.Options.serialize(buf[headerLen:])
 	return buf
 }
 
@@ -109,6 +120,11 @@ func (p *PacketRequest) IsError() bool {
 	return false
 }
 
+// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+func (p *PacketRequest) IsOAck() bool {
+	return false
+}
+
 // PacketData carries a block of data in a file transmission.
 type PacketData struct {
 	BlockNum uint16
@@ -164,6 +180,11 @@ func (p *PacketData) IsError() bool {
 	return false
 }
 
+// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+func (p *PacketData) IsOAck() bool {
+	return false
+}
+
 // PacketAck acknowledges receipt of a data packet
 type PacketAck struct {
 	BlockNum uint16
@@ -216,6 +237,11 @@ func (p *PacketAck) IsError() bool {
 	return false
 }
 
+// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+func (p *PacketAck) IsOAck() bool {
+	return false
+}
+
 // PacketError is sent by a peer who has encountered an error condition
 type PacketError struct {
 	Code ErrCode
@@ -275,6 +301,71 @@ func (p *PacketError) IsError() bool {
 	return true
 }
 
+// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+func (p *PacketError) IsOAck() bool {
+	return false
+}
+
+// PacketOAck acknowledges the options a server has accepted from a read or write request, per RFC 2347
+type PacketOAck struct {
+	Options Options
+}
+
+// Op returns the OpType code for this packet
+func (p *PacketOAck) Op() OpType {
+	return OpOAck
+}
+
+// Parse parses a packet
+func (p *PacketOAck) Parse(buf []byte) (err error) {
+	buf = buf[2:] // skip over op
+	if p.Options, err = parseOptions(buf); err != nil {
+		return err
+	}
+	if len(p.Options) == 0 {
+		return flog.Raise("option acknowledgement carries no options")
+	}
+	return nil
+}
+
+// Serialize serializes a packet to its wire representation
+func (p *PacketOAck) Serialize() []byte {
+	buf := make([]byte, 2+p.Options.size())
+	binary.BigEndian.PutUint16(buf, OpOAck)
+	p.Options.serialize(buf[2:])
+	return buf
+}
+
+// IsRRQ is for convenience, returns true if the packet is a read request packet
+func (p *PacketOAck) IsRRQ() bool {
+	return false
+}
+
+// IsWRQ is for convenience, returns true if the packet is a write request packet
+func (p *PacketOAck) IsWRQ() bool {
+	return false
+}
+
+// IsData is for convenience, returns true if the packet is a data packet
+func (p *PacketOAck) IsData() bool {
+	return false
+}
+
+// IsAck is for convenience, returns true if the packet is a ack packet
+func (p *PacketOAck) IsAck() bool {
+	return false
+}
+
+// IsError is for convenience, returns true if the packet is an error packet
+func (p *PacketOAck) IsError() bool {
+	return false
+}
+
+// IsOAck is for convenience, returns true if the packet is an option acknowledgement packet
+func (p *PacketOAck) IsOAck() bool {
+	return true
+}
+
 // parseUint16 reads a big-endian uint16 from the beginning of buf,
 // returning it along with a slice pointing at the next position in the buffer.
 func parseUint16(buf []byte) (uint16, []byte, error) {
@@ -309,6 +400,8 @@ func ParsePacket(buf []byte) (p Packet, err error) {
 		p = &PacketAck{}
 	case OpError:
 		p = &PacketError{}
+	case OpOAck:
+		p = &PacketOAck{}
 	default:
 		err = flog.Raisef("unexpected opcode %d", opcode)
 		return
@@ -17,14 +17,19 @@ func TestSerializationDeserialization(t *testing.T) {
 	}{
 		{
 			[]byte("\x00\x01foo\x00bar\x00"),
-			&PacketRequest{OpRRQ, "foo", "bar"},
+			&PacketRequest{OpRRQ, "foo", "bar", nil},
 			OpRRQ,
 		},
 		{
 			[]byte("\x00\x02foo\x00bar\x00"),
-			&PacketRequest{OpWRQ, "foo", "bar"},
+			&PacketRequest{OpWRQ, "foo", "bar", nil},
 			OpWRQ,
 		},
+		{
+			[]byte("\x00\x01foo\x00octet\x00blksize\x001428\x00tsize\x000\x00"),
+			&PacketRequest{OpRRQ, "foo", "octet", Options{{"blksize", "1428"}, {"tsize", "0"}}},
+			OpRRQ,
+		},
 		{
 			[]byte("\x00\x03\x12\x34fnord"),
 			&PacketData{0x1234, []byte("fnord")},
@@ -45,6 +50,11 @@ func TestSerializationDeserialization(t *testing.T) {
 			&PacketError{0xabcd, "parachute failure"},
 			OpError,
 		},
+		{
+			[]byte("\x00\x06blksize\x001428\x00"),
+			&PacketOAck{Options{{"blksize", "1428"}}},
+			OpOAck,
+		},
 	}
 
 	for _, test := range tests {
@@ -101,6 +111,13 @@ func TestSerializationDeserialization(t *testing.T) {
 		if msg, ok := tcore.TAssertBool(stm, gotB, wantB); !ok {
 			t.Error(msg)
 		}
+
+		stm = "Packet.IsOAck()"
+		gotB = actualPacket.IsOAck()
+		wantB = test.op == OpOAck
+		if msg, ok := tcore.TAssertBool(stm, gotB, wantB); !ok {
+			t.Error(msg)
+		}
 	}
 }
 
@@ -111,7 +128,7 @@ func TestDeserializationInvalid(t *testing.T) {
 
 		// invalid opcode
 		[]byte("\x00\x00"),
-		[]byte("\x00\x06"),
+		[]byte("\x00\x07"),
 		[]byte("\xff\x01"),
 		[]byte("\xff\xff"),
 
@@ -143,6 +160,10 @@ func TestDeserializationInvalid(t *testing.T) {
 
 		// truncated error
 		[]byte("\x05"),
+
+		// empty or truncated oack
+		[]byte("\x00\x06"),
+		[]byte("\x00\x06blksize\x00"),
 	}
 
 	for _, test := range tests {
@@ -0,0 +1,143 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"io"
+)
+
+// Window holds the blocks that the sending side of a transfer has read but that have not yet been acknowledged. Up to
+// windowSize blocks are sent before waiting for an acknowledgement (RFC 7440), a window size of one is the lock-step
+// transfer of RFC 1350. Blocks are counted from 1 without rolling over, and converted to the 16 bit numbers sent on the
+// wire by a function given to NewWindow. The server and the client both send files through a Window, so that they
+// recover from lost packets in the same way.
+type Window struct {
+	src     io.Reader
+	wire    func(blk uint64) uint16 // converts a block count to the block number sent on the wire
+	size    int                     // the window size
+	ring    [][]byte                // size reusable block buffers, block n is held in ring[n % size]
+	blocks  [][]byte                // the unacknowledged blocks, blocks[0] is block base
+	base    uint64                  // the oldest unacknowledged block
+	next    uint64                  // the next block to send
+	eof     bool                    // true once the final block, the first one shorter than blockSize, has been read
+	rewound uint64                  // the base of the window most recently resent because the receiver lost a block
+	read    int64                   // the number of bytes read from src
+}
+
+// NewWindow creates a Window that reads blocks of blockSize bytes from src. wire converts a block count to the block
+// number that is sent on the wire, which depends on how block numbers roll over after 65535.
+func NewWindow(src io.Reader, blockSize, windowSize int, wire func(blk uint64) uint16) *Window {
+	w := &Window{
+		src:    src,
+		wire:   wire,
+		size:   windowSize,
+		ring:   make([][]byte, windowSize),
+		blocks: make([][]byte, 0, windowSize),
+		base:   1,
+		next:   1,
+	}
+
+	for i := range w.ring {
+		w.ring[i] = make([]byte, blockSize)
+	}
+
+	return w
+}
+
+// Fill reads blocks from src until the window is full or src is exhausted
+func (w *Window) Fill() error {
+	for !w.eof && len(w.blocks) < w.size {
+		blk := w.base + uint64(len(w.blocks))
+		block := w.ring[blk%uint64(len(w.ring))]
+		n, err := io.ReadFull(w.src, block)
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			w.eof = true
+		} else if err != nil {
+			return err
+		}
+
+		w.read += int64(n)
+		w.blocks = append(w.blocks, block[:n])
+	}
+
+	return nil
+}
+
+// Done returns true once the final block has been acknowledged. Call Fill first, an empty window that has not been
+// filled is not done.
+func (w *Window) Done() bool {
+	return w.eof && len(w.blocks) == 0
+}
+
+// Next returns the next block in the window that has not been sent, and its number on the wire, and counts it as
+// sent. It returns false once every block in the window has been sent.
+func (w *Window) Next() (uint16, []byte, bool) {
+	if w.next >= w.base+uint64(len(w.blocks)) {
+		return 0, nil, false
+	}
+
+	blk := w.next
+	w.next++
+	return w.wire(blk), w.blocks[blk-w.base], true
+}
+
+// Rewind sends every unacknowledged block again, as when the receiver has not responded in time
+func (w *Window) Rewind() {
+	w.next = w.base
+}
+
+// Ack handles an acknowledgement of the block numbered ackNum on the wire, returning the number of bytes that it newly
+// acknowledges and true if it moved the window forward. An acknowledgement short of the last block sent means that
+// the receiver lost the block after it, and that block and those after it are sent again. Other acknowledgements are
+// duplicates or stale. They are ignored rather than answered with a retransmission, which would double every packet
+// for the rest of the transfer (the Sorcerer's Apprentice Syndrome).
+func (w *Window) Ack(ackNum uint16) (int64, bool) {
+	if blk, ok := w.inWindow(ackNum); ok {
+		var acked int64
+
+		for _, block := range w.blocks[:blk-w.base+1] {
+			acked += int64(len(block))
+		}
+
+		w.blocks = w.blocks[blk-w.base+1:]
+		w.base = blk + 1
+
+		if w.next > w.base {
+			w.next = w.base
+			w.rewound = w.base
+		}
+
+		return acked, true
+	}
+
+	if w.size > 1 && ackNum == w.wire(w.base-1) && w.rewound != w.base {
+		// the receiver lost the first block of the window. resend the window once, further repeats of this
+		// acknowledgement are answered by the blocks already on their way
+		w.next = w.base
+		w.rewound = w.base
+	}
+
+	return 0, false
+}
+
+// Base returns the oldest unacknowledged block, counted from 1
+func (w *Window) Base() uint64 {
+	return w.base
+}
+
+// BytesRead returns the number of bytes read from src so far
+func (w *Window) BytesRead() int64 {
+	return w.read
+}
+
+// inWindow returns the block that ackNum acknowledges if it has been sent but not yet acknowledged
+func (w *Window) inWindow(ackNum uint16) (uint64, bool) {
+	for blk := w.base; blk < w.next; blk++ {
+		if w.wire(blk) == ackNum {
+			return blk, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package cor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/webern/tcore"
+)
+
+func wire16(blk uint64) uint16 {
+	return uint16(blk)
+}
+
+// sendAll fills w and returns the wire numbers of the blocks it sends
+func sendAll(t *testing.T, w *Window) []uint16 {
+	if err := w.Fill(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var sent []uint16
+
+	for blk, _, ok := w.Next(); ok; blk, _, ok = w.Next() {
+		sent = append(sent, blk)
+	}
+
+	return sent
+}
+
+func assertSent(t *testing.T, got []uint16, want ...uint16) {
+	t.Helper()
+
+	if msg, ok := tcore.TAssertString("sent", fmtBlocks(got), fmtBlocks(want)); !ok {
+		t.Error(msg)
+	}
+}
+
+func fmtBlocks(blocks []uint16) string {
+	buf := bytes.Buffer{}
+
+	for _, b := range blocks {
+		buf.WriteString(string(rune('0' + b)))
+	}
+
+	return buf.String()
+}
+
+func TestWindow(t *testing.T) {
+	// seven blocks of 4 bytes and a short one
+	w := NewWindow(bytes.NewReader(make([]byte, 30)), 4, 3, wire16)
+	assertSent(t, sendAll(t, w), 1, 2, 3)
+
+	// an acknowledgement of the whole window
+	n, ok := w.Ack(3)
+
+	if !ok || n != 12 {
+		t.Errorf("Ack(3) = %d, %v, want 12, true", n, ok)
+	}
+
+	assertSent(t, sendAll(t, w), 4, 5, 6)
+
+	// block 4 was lost, the receiver repeats its acknowledgement of block 3 and the window is sent again, once
+	if _, ok = w.Ack(3); ok {
+		t.Error("a repeated acknowledgement should not move the window")
+	}
+
+	assertSent(t, sendAll(t, w), 4, 5, 6)
+
+	if _, ok = w.Ack(3); ok {
+		t.Error("a repeated acknowledgement should not move the window")
+	}
+
+	assertSent(t, sendAll(t, w))
+
+	// block 5 was lost, the window is sent again from it
+	if _, ok = w.Ack(4); !ok {
+		t.Error("Ack(4) should move the window")
+	}
+
+	assertSent(t, sendAll(t, w), 5, 6, 7)
+
+	// the repeats of that acknowledgement are answered by the blocks already sent
+	if _, ok = w.Ack(4); ok {
+		t.Error("a repeated acknowledgement should not move the window")
+	}
+
+	assertSent(t, sendAll(t, w))
+
+	// a timeout sends everything that is unacknowledged
+	w.Rewind()
+	assertSent(t, sendAll(t, w), 5, 6, 7)
+
+	if _, ok = w.Ack(7); !ok {
+		t.Error("Ack(7) should move the window")
+	}
+
+	assertSent(t, sendAll(t, w), 8)
+
+	if w.Done() {
+		t.Error("the window should not be done before the last block is acknowledged")
+	}
+
+	if n, ok = w.Ack(8); !ok || n != 2 {
+		t.Errorf("Ack(8) = %d, %v, want 2, true", n, ok)
+	}
+
+	if err := w.Fill(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !w.Done() {
+		t.Error("the window should be done")
+	}
+
+	if msg, ok := tcore.TAssertInt("w.BytesRead()", int(w.BytesRead()), 30); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestWindowEmpty(t *testing.T) {
+	// an empty file is sent as a single empty block
+	w := NewWindow(bytes.NewReader(nil), 512, 4, wire16)
+	assertSent(t, sendAll(t, w), 1)
+
+	if _, ok := w.Ack(1); !ok {
+		t.Error("Ack(1) should move the window")
+	}
+
+	_ = w.Fill()
+
+	if !w.Done() {
+		t.Error("the window should be done")
+	}
+}
+
+func TestWindowRollover(t *testing.T) {
+	// blocks count up from 65534, which roll over to 1 on the wire
+	wire := func(blk uint64) uint16 {
+		if blk <= 65535 {
+			return uint16(blk)
+		}
+
+		return uint16((blk-1)%65535 + 1)
+	}
+
+	w := NewWindow(bytes.NewReader(make([]byte, 65540)), 1, 2, wire)
+	w.base, w.next = 65534, 65534
+	_ = w.Fill()
+
+	if blk, _, _ := w.Next(); blk != 65534 {
+		t.Errorf("want block 65534, got %d", blk)
+	}
+
+	if blk, _, _ := w.Next(); blk != 65535 {
+		t.Errorf("want block 65535, got %d", blk)
+	}
+
+	if _, ok := w.Ack(65535); !ok {
+		t.Error("Ack(65535) should move the window")
+	}
+
+	_ = w.Fill()
+
+	if blk, _, _ := w.Next(); blk != 1 {
+		t.Errorf("want block 1 after rolling over, got %d", blk)
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"github.com/webern/tftp/lib/cor"
+)
+
+// Access decides which requests the server serves
+type Access int
+
+const (
+	// AccessReadWrite serves both RRQs and WRQs. This is the default.
+	AccessReadWrite Access = iota
+
+	// AccessReadOnly refuses every WRQ with ErrAccess, e.g. for a boot server
+	AccessReadOnly
+
+	// AccessWriteOnly refuses every RRQ with ErrAccess, e.g. for a drop box that collects logs from devices
+	AccessWriteOnly
+)
+
+// checkAccess returns the error a request is refused with under access, or nil if it may be served
+func checkAccess(access Access, request cor.PacketRequest) *cor.Err {
+	if access == AccessReadOnly && request.IsWRQ() {
+		return cor.NewErr(cor.ErrAccess, "the server is read-only")
+	} else if access == AccessWriteOnly && request.IsRRQ() {
+		return cor.NewErr(cor.ErrAccess, "the server is write-only")
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// Rule allows or denies the requests it matches. A rule matches a request when the client's address is in Network,
+// the request is of type Op, and the file name matches Pattern. The file name is matched in the one spelling that the
+// server hands to the store, with slashes for backslashes, without a leading slash and with "." and ".." resolved, so
+// that "./secret/key", "pxe/../secret/key" and "secret\key" are all matched as "secret/key".
+type Rule struct {
+	Allow   bool       // true to serve the requests matched, false to refuse them with ErrAccess
+	Op      cor.OpType // cor.OpRRQ or cor.OpWRQ, 0 for both
+	Network *net.IPNet // the client addresses matched, nil for all
+	Pattern string     // a path.Match pattern for the file name without a leading slash, e.g. "pxe/*", empty for all
+}
+
+// ParseRule parses a rule of the form "ACTION OP NETWORK [PATTERN]", where ACTION is allow or deny, OP is rrq, wrq or
+// any, NETWORK is a CIDR, an IP address or any, and PATTERN is a path.Match pattern for the file name, e.g.
+//
+//	deny wrq 0.0.0.0/0
+//	allow rrq 10.1.0.0/16 pxe/*
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	r := Rule{}
+
+	if len(fields) < 3 || len(fields) > 4 {
+		return r, flog.Raisef("the rule '%s' should have the form ACTION OP NETWORK [PATTERN]", s)
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "allow":
+		r.Allow = true
+	case "deny":
+		r.Allow = false
+	default:
+		return r, flog.Raisef("the rule '%s' should begin with allow or deny", s)
+	}
+
+	switch strings.ToLower(fields[1]) {
+	case "rrq":
+		r.Op = cor.OpRRQ
+	case "wrq":
+		r.Op = cor.OpWRQ
+	case "any":
+		r.Op = 0
+	default:
+		return r, flog.Raisef("the rule '%s' should apply to rrq, wrq or any", s)
+	}
+
+	if network := fields[2]; strings.ToLower(network) != "any" {
+		_, ipNet, err := net.ParseCIDR(network)
+
+		if err != nil {
+			ip := net.ParseIP(network)
+
+			if ip == nil {
+				return r, flog.Raisef("the rule '%s' has an invalid network '%s'", s, network)
+			}
+
+			// a single address
+			bits := 8 * len(ip.To4())
+
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+
+			ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+
+		r.Network = ipNet
+	}
+
+	if len(fields) == 4 {
+		if _, err := path.Match(fields[3], ""); err != nil {
+			return r, flog.Raisef("the rule '%s' has an invalid pattern '%s'", s, fields[3])
+		}
+
+		r.Pattern = strings.TrimLeft(fields[3], "/")
+	}
+
+	return r, nil
+}
+
+// String returns the rule in the form read by ParseRule
+func (r Rule) String() string {
+	action, op, network := "deny", "any", "any"
+
+	if r.Allow {
+		action = "allow"
+	}
+
+	if r.Op == cor.OpRRQ {
+		op = "rrq"
+	} else if r.Op == cor.OpWRQ {
+		op = "wrq"
+	}
+
+	if r.Network != nil {
+		network = r.Network.String()
+	}
+
+	return strings.TrimSpace(strings.Join([]string{action, op, network, r.Pattern}, " "))
+}
+
+// matches returns true if the rule applies to a request of type op for filename from the address ip
+func (r Rule) matches(ip net.IP, op cor.OpType, filename string) bool {
+	if r.Op != 0 && r.Op != op {
+		return false
+	}
+
+	if r.Network != nil && !r.Network.Contains(ip) {
+		return false
+	}
+
+	if len(r.Pattern) > 0 {
+		if ok, _ := path.Match(r.Pattern, canonicalName(filename)); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkRules finds the first of rules that matches the request from the address ip. If that rule denies the request,
+// the ErrAccess error the client should be sent is returned along with the rule. A request that no rule matches is
+// allowed.
+func checkRules(rules []Rule, request cor.PacketRequest, ip net.IP) (*cor.Err, Rule) {
+	for _, rule := range rules {
+		if !rule.matches(ip, request.OpCode, request.Filename) {
+			continue
+		}
+
+		if rule.Allow {
+			return nil, rule
+		}
+
+		return cor.NewErr(cor.ErrAccess, "access denied"), rule
+	}
+
+	return nil, Rule{}
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestParseRule(t *testing.T) {
+	valid := map[string]string{
+		"allow rrq 10.0.0.0/8":          "allow rrq 10.0.0.0/8",
+		"DENY wrq any":                  "deny wrq any",
+		"allow any 192.168.1.7 pxe/*":   "allow any 192.168.1.7/32 pxe/*",
+		"deny rrq fe80::/10 *.cfg":      "deny rrq fe80::/10 *.cfg",
+		"  allow   any   any  ":         "allow any any",
+		"deny wrq 10.1.2.3/16 upload/*": "deny wrq 10.1.0.0/16 upload/*",
+	}
+
+	for s, want := range valid {
+		rule, err := ParseRule(s)
+
+		if err != nil {
+			t.Errorf("ParseRule(%q): %s", s, err.Error())
+		} else if got := rule.String(); got != want {
+			t.Errorf("ParseRule(%q).String() = %q, want %q", s, got, want)
+		}
+	}
+
+	invalid := []string{"", "allow", "allow rrq", "permit rrq any", "allow get any", "allow rrq 10.0.0.0/33",
+		"allow rrq nowhere", "allow rrq any [", "allow rrq any a b"}
+
+	for _, s := range invalid {
+		if _, err := ParseRule(s); err == nil {
+			t.Errorf("ParseRule(%q) should have failed", s)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	var rules []Rule
+
+	for _, s := range []string{
+		"deny any 10.0.0.66",
+		"deny rrq any secret/*",
+		"allow wrq 10.0.0.0/24 logs/*",
+		"deny wrq any",
+		"allow rrq 10.0.0.0/8",
+		"deny any any",
+	} {
+		rule, err := ParseRule(s)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		rules = append(rules, rule)
+	}
+
+	tests := []struct {
+		ip      string
+		op      cor.OpType
+		name    string
+		allowed bool
+	}{
+		{"10.0.0.5", cor.OpRRQ, "boot.img", true},
+		{"10.9.0.5", cor.OpRRQ, "boot.img", true},
+		{"10.0.0.66", cor.OpRRQ, "boot.img", false},
+		{"10.0.0.5", cor.OpWRQ, "logs/a.log", true},
+		{"10.0.0.5", cor.OpWRQ, "boot.img", false},
+		{"10.9.0.5", cor.OpWRQ, "logs/a.log", false},
+		{"192.168.0.1", cor.OpRRQ, "boot.img", false},
+
+		// other spellings of a denied name are denied
+		{"10.0.0.5", cor.OpRRQ, "secret/key", false},
+		{"10.0.0.5", cor.OpRRQ, "./secret/key", false},
+		{"10.0.0.5", cor.OpRRQ, "pxe/../secret/key", false},
+		{"10.0.0.5", cor.OpRRQ, "secret\\key", false},
+		{"10.0.0.5", cor.OpRRQ, "secret//key", false},
+		{"10.0.0.5", cor.OpRRQ, "/secret/key", false},
+		{"10.0.0.5", cor.OpRRQ, "\\secret\\key", false},
+	}
+
+	for _, test := range tests {
+		request := cor.PacketRequest{OpCode: test.op, Filename: test.name, Mode: "octet"}
+		e, _ := checkRules(rules, request, net.ParseIP(test.ip))
+
+		if test.allowed && e != nil {
+			t.Errorf("%s of '%s' from %s should be allowed", test.op.String(), test.name, test.ip)
+		} else if !test.allowed && (e == nil || e.Code() != cor.ErrAccess) {
+			t.Errorf("%s of '%s' from %s should be denied", test.op.String(), test.name, test.ip)
+		}
+	}
+
+	// with no rules, everything is allowed
+	if e, _ := checkRules(nil, cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "x"}, net.ParseIP("1.2.3.4")); e != nil {
+		t.Error("a request with no rules should be allowed")
+	}
+}
+
+func TestCanonicalName(t *testing.T) {
+	names := map[string]string{
+		"boot.img":            "boot.img",
+		"/pxe/boot.img":       "pxe/boot.img",
+		"pxe//boot.img":       "pxe/boot.img",
+		"./pxe/./boot.img":    "pxe/boot.img",
+		"pxe\\boot.img":       "pxe/boot.img",
+		"cfg/../pxe/boot.img": "pxe/boot.img",
+		"/../pxe/boot.img":    "pxe/boot.img",
+		"../boot.img":         "../boot.img",
+		"":                    "",
+	}
+
+	for name, want := range names {
+		if got := canonicalName(name); got != want {
+			t.Errorf("canonicalName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
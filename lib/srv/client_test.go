@@ -0,0 +1,104 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// fakeClient speaks raw TFTP packets to a server so that tests can control exactly what is sent and observe exactly
+// what is received
+type fakeClient struct {
+	conn   *net.UDPConn
+	server *net.UDPAddr // the server's listening address until the first reply, then the server's transfer address
+	buf    []byte
+}
+
+func newFakeClient(serverPort int) (*fakeClient, error) {
+	server, err := net.ResolveUDPAddr("udp", fmt.Sprintf("127.0.0.1:%d", serverPort))
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeClient{conn: conn, server: server, buf: make([]byte, cor.MaxBlockSize+4)}, nil
+}
+
+func (c *fakeClient) send(p cor.Packet) error {
+	_, err := c.conn.WriteToUDP(p.Serialize(), c.server)
+	return err
+}
+
+// receive waits up to one second for a packet from the server, and remembers the server's transfer address
+func (c *fakeClient) receive() (cor.Packet, error) {
+	return c.receiveWithin(time.Second)
+}
+
+func (c *fakeClient) receiveWithin(wait time.Duration) (cor.Packet, error) {
+	err := c.conn.SetReadDeadline(time.Now().Add(wait))
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, addr, err := c.conn.ReadFromUDP(c.buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.server = addr
+	return cor.ParsePacket(c.buf[:n])
+}
+
+func (c *fakeClient) close() {
+	_ = c.conn.Close()
+}
+
+// startTestServer runs a server with a memStore on port, returning a function that stops it. configure, if not nil, is
+// called before the server starts.
+func startTestServer(t *testing.T, port int, configure func(s *Server), files ...cor.File) (*Server, func()) {
+	store := stor.NewMemStore()
+
+	for _, f := range files {
+		if err := store.Put(f); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	server := NewServer(store)
+	server.Port = port
+
+	if configure != nil {
+		configure(&server)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		if err := server.Serve(); err != nil {
+			flog.Error(err.Error())
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	return &server, func() {
+		_ = server.Stop()
+		<-done
+	}
+}
@@ -4,7 +4,10 @@ package srv
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"time"
 
 	"github.com/webern/flog"
 	"github.com/webern/tftp/lib/cor"
@@ -12,159 +15,235 @@ import (
 )
 
 // get transfers data from the store to a UDP TFTP Client
-func get(hndshk handshake, store stor.Store) (conn *net.UDPConn, numBytes int, err error) {
+func get(hndshk handshake, store stor.Store) (conn *net.UDPConn, stats transferStats, err error) {
 	conn, err = net.DialUDP("udp", &hndshk.server, &hndshk.client)
 
 	if err != nil {
 		_ = sendErr(conn, cor.ErrNotFound, err.Error())
-		return nil, 0, flog.Wrap(err)
+		return nil, stats, flog.Wrap(err)
 	}
 
-	theFile := cor.File{}
-	theFile.Name = hndshk.tftpInfo.Filename
-	theFile, err = store.Get(theFile.Name)
+	defer hndshk.watchCancel(conn)()
 
-	if err != nil {
-		return conn, 0, cor.NewErr(cor.ErrNotFound, fmt.Sprintf("the file '%s' could not be found", hndshk.tftpInfo.Filename))
-	}
+	// the file is read a block at a time as the transfer proceeds, rather than loaded into memory up front
+	file, size, err := stor.AsStreamer(store).Open(hndshk.tftpInfo.Filename)
 
-	if err := sendHandshakeAck(conn); err != nil {
-		return conn, 0, cor.NewErrf(cor.ErrUnknown, "acknowledgement packet could not be sent")
+	if e, ok := err.(*cor.Err); ok {
+		// the store knows why, e.g. a DirStore refusing a path outside of its root with ErrAccess
+		return conn, stats, e
+	} else if err != nil {
+		return conn, stats, cor.NewErr(cor.ErrNotFound, fmt.Sprintf("the file '%s' could not be found", hndshk.tftpInfo.Filename))
 	}
 
-	numBytes = len(theFile.Data)
-	blk := 1
+	defer func() { _ = file.Close() }()
 
-	buf := packetPool.Get().([]byte)
-	memset(buf)
-	defer packetPool.Put(buf)
-	sendEmptyAtEnd := len(theFile.Data)%cor.BlockSize == 0
+	// the client asks for the transfer size with tsize=0, answer with the actual size of the file
+	if _, ok := hndshk.oack.Get(cor.OptTransferSize); ok {
+		hndshk.oack.Set(cor.OptTransferSize, strconv.FormatInt(size, 10))
+	}
 
-	for pos := 0; pos < len(theFile.Data); {
-		end := pos + cor.BlockSize
+	buf := getPacketBuf(hndshk.blockSize)
+	defer putPacketBuf(buf)
 
-		if end > len(theFile.Data) {
-			end = len(theFile.Data)
-		}
+	var src io.Reader = file
 
-		err := sendDataPacket(hndshk, blk, conn, &theFile, pos, buf)
+	if hndshk.netascii {
+		src = cor.NewNetasciiReader(src)
+	}
 
-		if err != nil {
-			return conn, 0, flog.Wrap(err)
-		}
+	s := newSender(hndshk, conn, src, buf)
 
-		blk++
-		pos = end
+	// if options were negotiated, the client acknowledges our OACK with block 0 before we send any data
+	if len(hndshk.oack) > 0 {
+		err = s.sendOAck()
 	}
 
-	if sendEmptyAtEnd {
-		err := sendEmptyEnding(hndshk, blk, conn, buf)
+	if err == nil {
+		err = s.run()
+	}
 
-		if err != nil {
-			return conn, 0, flog.Wrap(err)
-		}
+	// in netascii mode this counts the bytes sent on the wire rather than the bytes of the file
+	stats.numBytes = s.window.BytesRead()
+	stats.retries = s.retries
+
+	if err != nil {
+		return conn, stats, err
 	}
 
-	return conn, numBytes, nil
+	return conn, stats, nil
 }
 
-func sendEmptyEnding(hndshk handshake, block int, conn *net.UDPConn, buf []byte) error {
-	data := cor.PacketData{}
-	data.BlockNum = uint16(block)
-	data.Data = make([]byte, 0)
-	_, err := conn.Write(data.Serialize())
+// sender streams blocks from src to the client through a window of unacknowledged blocks, which are sent again when
+// the client reports a loss or stops responding
+type sender struct {
+	hndshk  handshake
+	conn    *net.UDPConn
+	buf     []byte      // receives acknowledgements
+	window  *cor.Window // the blocks that have not been acknowledged
+	retries int         // the number of times the client failed to respond in time, over the whole transfer
+}
 
-	if err != nil {
-		return err
+func newSender(hndshk handshake, conn *net.UDPConn, src io.Reader, buf []byte) *sender {
+	return &sender{
+		hndshk: hndshk,
+		conn:   conn,
+		buf:    buf,
+		window: cor.NewWindow(src, hndshk.blockSize, hndshk.windowSize, hndshk.wireBlock),
 	}
+}
 
-	n, addr, err := conn.ReadFromUDP(buf)
+// sendOAck sends the OACK and waits for the client to acknowledge it with block 0, sending it again if the client
+// does not respond in time
+func (s *sender) sendOAck() error {
+	for attempt := 0; ; attempt++ {
+		if err := sendOAck(s.conn, s.hndshk.oack); err != nil {
+			return flog.Raisef("option acknowledgement packet could not be sent: %s", err.Error())
+		}
 
-	if err != nil {
-		return err
-	}
+		ackNum, err := s.readAck()
 
-	if addr.Port != hndshk.client.Port {
-		return flog.Raisef("wrong client port, got %d, want %d", addr.Port, hndshk.client.Port)
-	}
+		for err == nil && ackNum != 0 {
+			// stale, keep waiting for the acknowledgement of the OACK
+			ackNum, err = s.readAck()
+		}
 
-	if n <= 0 {
-		return flog.Raisef("bad acknowledgement packet")
-	}
+		if !isTimeout(err) {
+			return err
+		}
 
-	packet, err := cor.ParsePacket(buf)
+		s.retries++
 
-	if err != nil {
-		return flog.Wrap(err)
+		if attempt >= s.hndshk.retries {
+			return cor.NewErrf(cor.ErrUnknown, "the option acknowledgement was not acknowledged after %d retries", s.hndshk.retries)
+		}
 	}
+}
 
-	if !packet.IsAck() {
-		return flog.Raise("wrong packet type")
-	}
+// run sends the whole of src, returning when the final block has been acknowledged. When the client does not respond
+// in time, the unacknowledged blocks are sent again, up to the transfer's retry limit.
+func (s *sender) run() error {
+	retries := 0
 
-	ack, ok := packet.(*cor.PacketAck)
+	for {
+		if err := s.window.Fill(); err != nil {
+			return flog.Wrap(err)
+		}
 
-	if !ok {
-		flog.Bug()
+		if s.window.Done() {
+			return nil
+		}
+
+		for blk, data, ok := s.window.Next(); ok; blk, data, ok = s.window.Next() {
+			if err := s.send(blk, data); err != nil {
+				return err
+			}
+		}
+
+		ackNum, err := s.readAck()
+
+		if isTimeout(err) {
+			// resend everything that has not been acknowledged
+			retries++
+			s.retries++
+
+			if retries > s.hndshk.retries {
+				return cor.NewErrf(cor.ErrUnknown, "block %d was not acknowledged after %d retries", s.window.Base(), s.hndshk.retries)
+			}
+
+			s.window.Rewind()
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if _, ok := s.window.Ack(ackNum); ok {
+			retries = 0
+		}
 	}
+}
 
-	if ack.BlockNum != uint16(block) {
-		return flog.Raisef("wrong block ack, got %d, want %d", ack.BlockNum, block)
+// send writes the block numbered blk on the wire to the client, once the rate limits allow it
+func (s *sender) send(blk uint16, block []byte) error {
+	if err := s.hndshk.pace(len(block)); err != nil {
+		return err
 	}
 
-	return nil
+	data := cor.PacketData{}
+	data.BlockNum = blk
+	data.Data = block
+	_, err := s.conn.Write(data.Serialize())
+	return err
 }
 
-func sendDataPacket(hndshk handshake, blk int, conn *net.UDPConn, theFile *cor.File, pos int, buf []byte) error {
-	data := cor.PacketData{}
-	data.BlockNum = uint16(blk)
-	end := pos + cor.BlockSize
+// readAck waits up to the transfer's timeout for an acknowledgement from the client
+func (s *sender) readAck() (uint16, error) {
+	err := s.conn.SetReadDeadline(time.Now().Add(s.hndshk.timeout))
 
-	if end > len(theFile.Data) {
-		end = len(theFile.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = s.hndshk.canceled(); err != nil {
+		return 0, err
 	}
 
-	data.Data = theFile.Data[pos:end]
-	_, err := conn.Write(data.Serialize())
+	n, addr, err := s.conn.ReadFromUDP(s.buf)
+
+	if isTimeout(err) {
+		// the deadline may have been cut short because the transfer was abandoned
+		if cancelErr := s.hndshk.canceled(); cancelErr != nil {
+			return 0, cancelErr
+		}
+	}
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	n, addr, err := conn.ReadFromUDP(buf)
+	ack, err := parseAck(s.hndshk, s.buf[:n], addr)
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	return ack.BlockNum, nil
+}
+
+// parseAck checks that buf holds an acknowledgement from the client
+func parseAck(hndshk handshake, buf []byte, addr *net.UDPAddr) (*cor.PacketAck, error) {
 	if addr.Port != hndshk.client.Port {
-		return flog.Raisef("wrong client port, got %d, want %d", addr.Port, hndshk.client.Port)
+		return nil, flog.Raisef("wrong client port, got %d, want %d", addr.Port, hndshk.client.Port)
 	}
 
-	if n <= 0 {
-		return flog.Raisef("bad acknowledgement packet")
+	if len(buf) <= 0 {
+		return nil, flog.Raisef("bad acknowledgement packet")
 	}
 
 	packet, err := cor.ParsePacket(buf)
 
 	if err != nil {
-		return flog.Wrap(err)
+		return nil, flog.Wrap(err)
+	}
+
+	if packet.IsError() {
+		return nil, flog.Raise("error received from client")
 	}
 
 	if !packet.IsAck() {
-		return flog.Raise("wrong packet type")
+		return nil, flog.Raise("wrong packet type")
 	}
 
 	ack, ok := packet.(*cor.PacketAck)
 
 	if !ok {
-		return flog.Raise("bug, could not downcast packet")
+		return nil, flog.Raise("bug, could not downcast packet")
 	}
 
-	if ack.BlockNum != uint16(blk) {
-		return flog.Raisef("wrong block ack, got %d, want %d", ack.BlockNum, blk)
-
-	}
+	return ack, nil
+}
 
-	return nil
+// isTimeout returns true if err is a read deadline expiring
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
 }
@@ -0,0 +1,403 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// startGet sends a RRQ with options and acknowledges the server's OACK
+func startGet(t *testing.T, client *fakeClient, filename string, options cor.Options) {
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: filename, Mode: "octet", Options: options}
+
+	if err := client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !packet.IsOAck() {
+		t.Fatalf("want an oack packet, got op %s", packet.Op().String())
+	}
+
+	if err = client.send(&cor.PacketAck{BlockNum: 0}); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// receiveData waits for a data packet and fails the test if something else arrives
+func receiveData(t *testing.T, client *fakeClient) *cor.PacketData {
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, ok := packet.(*cor.PacketData)
+
+	if !ok {
+		t.Fatalf("want a data packet, got op %s", packet.Op().String())
+	}
+
+	return data
+}
+
+func TestGetWindowSize(t *testing.T) {
+	// ten full blocks and a short one
+	file := cor.File{Name: "window-get.bin", Data: makeTestData(10*cor.BlockSize + 100)}
+	_, stop := startTestServer(t, 11117, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11117)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	startGet(t, client, file.Name, cor.Options{{Name: cor.OptWindowSize, Value: "4"}})
+
+	got := make([]byte, 0)
+	dataPackets := 0
+	acks := 0
+	want := uint16(1)
+
+	for done := false; !done; {
+		// the server sends a whole window before waiting to hear from us
+		for i := 0; i < 4; i++ {
+			data := receiveData(t, client)
+			dataPackets++
+
+			if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), int(want)); !ok {
+				t.Fatal(msg)
+			}
+
+			got = append(got, data.Data...)
+			want++
+
+			if len(data.Data) < cor.BlockSize {
+				done = true
+				break
+			}
+		}
+
+		if err = client.send(&cor.PacketAck{BlockNum: want - 1}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		acks++
+	}
+
+	if msg, ok := tcore.TAssertInt("dataPackets", dataPackets, 11); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("acks", acks, 3); !ok {
+		t.Error(msg)
+	}
+
+	if !bytes.Equal(got, file.Data) {
+		t.Error("the received data does not match the file")
+	}
+}
+
+func TestGetWindowSizeLoss(t *testing.T) {
+	file := cor.File{Name: "window-loss.bin", Data: makeTestData(10 * cor.BlockSize)}
+	_, stop := startTestServer(t, 11118, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11118)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	startGet(t, client, file.Name, cor.Options{{Name: cor.OptWindowSize, Value: "4"}})
+
+	for blk := 1; blk <= 4; blk++ {
+		receiveData(t, client)
+	}
+
+	// pretend block 3 was lost, the server should slide the window to 3 and send 3 through 6
+	if err = client.send(&cor.PacketAck{BlockNum: 2}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for blk := 3; blk <= 6; blk++ {
+		data := receiveData(t, client)
+
+		if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), blk); !ok {
+			t.Fatal(msg)
+		}
+	}
+
+	_ = client.send(&cor.PacketError{Code: cor.ErrUnknown, Msg: "done"})
+}
+
+func TestGetRetransmit(t *testing.T) {
+	file := cor.File{Name: "retransmit.bin", Data: makeTestData(cor.BlockSize + 10)}
+	_, stop := startTestServer(t, 11121, func(s *Server) { s.Timeout = 100 * time.Millisecond }, file)
+	defer stop()
+
+	client, err := newFakeClient(11121)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// our acknowledgement of the first block is 'lost', the server should send it again
+	for i := 0; i < 2; i++ {
+		data := receiveData(t, client)
+
+		if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), 1); !ok {
+			t.Fatal(msg)
+		}
+	}
+
+	_ = client.send(&cor.PacketAck{BlockNum: 1})
+	data := receiveData(t, client)
+
+	if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), 2); !ok {
+		t.Fatal(msg)
+	}
+
+	_ = client.send(&cor.PacketAck{BlockNum: 2})
+}
+
+func TestGetGivesUp(t *testing.T) {
+	client, err := newFakeClient(0)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	store := stor.NewMemStore()
+	_ = store.Put(cor.File{Name: "givesup.bin", Data: makeTestData(100)})
+	server, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	h := makeTestHandshake(client.conn.LocalAddr().(*net.UDPAddr), server, cor.OpRRQ, "givesup.bin")
+	h.timeout = 50 * time.Millisecond
+	h.retries = 2
+	lch := make(chan LogEntry, 1)
+	go doAsyncTransfer(h, store, LogEntry{Start: time.Now()}, lch, get)
+
+	// the first transmission and two retries
+	for i := 0; i < 3; i++ {
+		receiveData(t, client)
+	}
+
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !packet.IsError() {
+		t.Fatalf("want an error packet, got op %s", packet.Op().String())
+	}
+
+	l := <-lch
+
+	if l.Error == nil {
+		t.Error("the log entry should have an error")
+	}
+
+	if msg, ok := tcore.TAssertInt("l.Retries", l.Retries, 3); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetDuplicateAck(t *testing.T) {
+	file := cor.File{Name: "duplicate-get.bin", Data: makeTestData(2*cor.BlockSize + 10)}
+	_, stop := startTestServer(t, 11123, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11123)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	receiveData(t, client)
+
+	// acknowledge block 1 twice. the duplicate must not trigger a second copy of block 2, which would lead to the
+	// Sorcerer's Apprentice Syndrome
+	_ = client.send(&cor.PacketAck{BlockNum: 1})
+	_ = client.send(&cor.PacketAck{BlockNum: 1})
+	data := receiveData(t, client)
+
+	if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), 2); !ok {
+		t.Fatal(msg)
+	}
+
+	if packet, err := client.receiveWithin(200 * time.Millisecond); err == nil {
+		t.Fatalf("unexpected packet, op %s", packet.Op().String())
+	}
+
+	// a stale acknowledgement from long ago is also ignored
+	_ = client.send(&cor.PacketAck{BlockNum: 0})
+	_ = client.send(&cor.PacketAck{BlockNum: 2})
+	data = receiveData(t, client)
+
+	if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), 3); !ok {
+		t.Fatal(msg)
+	}
+
+	_ = client.send(&cor.PacketAck{BlockNum: 3})
+}
+
+func TestGetRollover(t *testing.T) {
+	// more than 65535 blocks of 8 bytes, the last of which is short
+	const numBlocks = 65540
+	file := cor.File{Name: "rollover.bin", Data: makeTestData(numBlocks*8 - 3)}
+	_, stop := startTestServer(t, 11124, nil, file)
+	defer stop()
+
+	for _, rollover := range []uint16{0, 1} {
+		client, err := newFakeClient(11124)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		options := cor.Options{
+			{Name: cor.OptBlockSize, Value: "8"},
+			{Name: cor.OptWindowSize, Value: "64"},
+			{Name: cor.OptRollover, Value: fmt.Sprintf("%d", rollover)},
+		}
+
+		startGet(t, client, file.Name, options)
+		h := handshake{rollover: rollover}
+		got := make([]byte, 0, len(file.Data))
+
+		for blk := uint64(1); blk <= numBlocks; blk++ {
+			data := receiveData(t, client)
+
+			if data.BlockNum != h.wireBlock(blk) {
+				t.Fatalf("rollover %d, block %d: want block number %d, got %d", rollover, blk, h.wireBlock(blk), data.BlockNum)
+			}
+
+			got = append(got, data.Data...)
+
+			if blk%64 == 0 || blk == numBlocks {
+				_ = client.send(&cor.PacketAck{BlockNum: data.BlockNum})
+			}
+		}
+
+		client.close()
+
+		if !bytes.Equal(got, file.Data) {
+			t.Errorf("rollover %d: the received data does not match the file", rollover)
+		}
+	}
+}
+
+func TestGetNetascii(t *testing.T) {
+	// the line ending falls at the end of the first block, so its CR LF straddles two blocks
+	local := string(bytes.Repeat([]byte("x"), cor.BlockSize-1)) + "\nbare\rcarriage return\n"
+	want := string(bytes.Repeat([]byte("x"), cor.BlockSize-1)) + "\r\nbare\r\x00carriage return\r\n"
+	file := cor.File{Name: "netascii-get.txt", Data: []byte(local)}
+	_, stop := startTestServer(t, 11126, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11126)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "NETASCII"}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got := bytes.Buffer{}
+
+	for blk := 1; ; blk++ {
+		data := receiveData(t, client)
+
+		if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), blk); !ok {
+			t.Fatal(msg)
+		}
+
+		got.Write(data.Data)
+		_ = client.send(&cor.PacketAck{BlockNum: data.BlockNum})
+
+		if len(data.Data) < cor.BlockSize {
+			break
+		}
+	}
+
+	if msg, ok := tcore.TAssertString("received", got.String(), want); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetMailMode(t *testing.T) {
+	file := cor.File{Name: "mail-get.txt", Data: []byte("hello\n")}
+	_, stop := startTestServer(t, 11127, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11127)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "mail"}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	pktErr, ok := packet.(*cor.PacketError)
+
+	if !ok {
+		t.Fatalf("want an error packet, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("pktErr.Code", int(pktErr.Code), int(cor.ErrBadOp)); !ok {
+		t.Error(msg)
+	}
+}
@@ -3,7 +3,10 @@
 package srv
 
 import (
+	"context"
+	"math"
 	"net"
+	"time"
 
 	"github.com/webern/tftp/lib/cor"
 )
@@ -11,7 +14,83 @@ import (
 // handshake represents a handshake between the client and the server. it contains the client's port number, the
 // server's port number, and the operation type
 type handshake struct {
-	tftpInfo cor.PacketRequest
-	client   net.UDPAddr // the client's declared port for the transfer
-	server   net.UDPAddr // the server's declared port for the transfer
+	tftpInfo   cor.PacketRequest
+	client     net.UDPAddr     // the client's declared port for the transfer
+	server     net.UDPAddr     // the server's declared port for the transfer
+	oack       cor.Options     // the options the server accepted, echoed to the client in an OACK. nil if none
+	blockSize  int             // the number of data bytes per DATA packet, cor.BlockSize unless negotiated
+	maxBytes   int64           // the largest file a put will accept, 0 for no limit
+	timeout    time.Duration   // how long to wait for the client before retransmitting
+	retries    int             // how many times to retransmit before giving up
+	windowSize int             // the number of blocks sent before waiting for an acknowledgement, 1 unless negotiated
+	rollover   uint16          // the block number that follows 65535, either 0 or 1
+	netascii   bool            // true if the file is translated to and from netascii on the wire, false for octet mode
+	policy     WritePolicy     // whether a put may store the file
+	ctx        context.Context // canceled when the server abandons the transfer, nil if it never does
+	pacer      *pacer          // paces the bytes transferred to the server's rate limits, nil for no limit
+}
+
+// wireBlock converts a block count, which starts at 1 and never rolls over, to the 16 bit block number that is sent on
+// the wire. Block numbers roll over after 65535 to either 0 or 1.
+func (h *handshake) wireBlock(blk uint64) uint16 {
+	if h.rollover == 0 || blk <= math.MaxUint16 {
+		return uint16(blk)
+	}
+
+	return uint16((blk-1)%math.MaxUint16 + 1)
+}
+
+// canceled returns the error the client is sent if the server has abandoned the transfer, or nil
+func (h *handshake) canceled() error {
+	if h.ctx != nil && h.ctx.Err() != nil {
+		return cor.NewErr(cor.ErrUnknown, "the server is shutting down")
+	}
+
+	return nil
+}
+
+// pace waits until n more bytes may be transferred within the server's rate limits. An error is returned if the server
+// abandons the transfer while waiting.
+func (h *handshake) pace(n int) error {
+	delay := h.pacer.delay(n)
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var done <-chan struct{}
+
+	if h.ctx != nil {
+		done = h.ctx.Done()
+	}
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-done:
+		return h.canceled()
+	}
+}
+
+// watchCancel interrupts any read on conn when the server abandons the transfer, so that the transfer ends promptly
+// rather than at its next timeout. Readers must check canceled after setting a read deadline and before reading, so that
+// the deadline they set cannot replace the one set here. The returned function stops watching.
+func (h *handshake) watchCancel(conn *net.UDPConn) func() {
+	if h.ctx == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+
+	go func() {
+		select {
+		case <-h.ctx.Done():
+			_ = conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return func() { close(stop) }
 }
@@ -0,0 +1,40 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/webern/tcore"
+)
+
+func TestWireBlock(t *testing.T) {
+	tests := []struct {
+		rollover uint16
+		blk      uint64
+		want     uint16
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0, 65535, 65535},
+		{0, 65536, 0},
+		{0, 65537, 1},
+		{0, 131072, 0},
+		{1, 0, 0},
+		{1, 1, 1},
+		{1, 65535, 65535},
+		{1, 65536, 1},
+		{1, 65537, 2},
+		{1, 131070, 65535},
+		{1, 131071, 1},
+	}
+
+	for _, test := range tests {
+		h := handshake{rollover: test.rollover}
+		stm := fmt.Sprintf("rollover %d, h.wireBlock(%d)", test.rollover, test.blk)
+		if msg, ok := tcore.TAssertInt(stm, int(h.wireBlock(test.blk)), int(test.want)); !ok {
+			t.Error(msg)
+		}
+	}
+}
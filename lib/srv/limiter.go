@@ -0,0 +1,111 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/webern/tftp/lib/cor"
+)
+
+// limiter counts the transfers in progress, overall and for each client, so that they can be kept within limits
+type limiter struct {
+	mx       sync.Mutex
+	total    int             // the transfers in progress
+	byClient map[string]int  // the transfers in progress for each client IP address
+	freed    chan struct{}   // closed, and replaced, whenever a transfer ends
+	requests map[string]bool // the client addresses, IP:port, with a request waiting or running
+}
+
+func newLimiter() *limiter {
+	return &limiter{byClient: make(map[string]int), freed: make(chan struct{}), requests: make(map[string]bool)}
+}
+
+// claim records that the client at addr, an IP:port, has a request waiting or running. It returns false if one
+// already is, in which case the request is a client resending a request it has not yet been answered for, and should
+// be dropped rather than queued or served a second time.
+func (l *limiter) claim(addr string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if l.requests[addr] {
+		return false
+	}
+
+	l.requests[addr] = true
+	return true
+}
+
+// unclaim records that the request claimed for addr has been refused or has ended
+func (l *limiter) unclaim(addr string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	delete(l.requests, addr)
+}
+
+// acquire counts a transfer for client if it fits within maxTotal and maxPerClient, where 0 means no limit. If it does
+// not fit, acquire waits up to wait for other transfers to end, or until ctx is done. An error is returned if the
+// transfer still does not fit, which the client should be sent.
+func (l *limiter) acquire(ctx context.Context, client string, maxTotal, maxPerClient int, wait time.Duration) *cor.Err {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		l.mx.Lock()
+
+		if (maxTotal <= 0 || l.total < maxTotal) && (maxPerClient <= 0 || l.byClient[client] < maxPerClient) {
+			l.total++
+			l.byClient[client]++
+			l.mx.Unlock()
+			return nil
+		}
+
+		freed := l.freed
+		l.mx.Unlock()
+
+		select {
+		case <-freed:
+		case <-timer.C:
+			return cor.NewErr(cor.ErrUnknown, "the server is busy, try again later")
+		case <-ctx.Done():
+			return cor.NewErr(cor.ErrUnknown, "the server is shutting down")
+		}
+	}
+}
+
+// release ends a transfer counted by acquire, waking any transfers waiting for it
+func (l *limiter) release(client string) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.total--
+	l.byClient[client]--
+
+	if l.byClient[client] <= 0 {
+		delete(l.byClient, client)
+	}
+
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// active returns the number of transfers in progress
+func (l *limiter) active() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.total
+}
+
+// activeByClient returns a copy of the number of transfers in progress for each client IP address
+func (l *limiter) activeByClient() map[string]int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	counts := make(map[string]int, len(l.byClient))
+
+	for client, n := range l.byClient {
+		counts[client] = n
+	}
+
+	return counts
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webern/tcore"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter()
+	ctx := context.Background()
+
+	for _, client := range []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"} {
+		if e := l.acquire(ctx, client, 3, 2, 0); e != nil {
+			t.Fatalf("acquire(%s): %s", client, e.Error())
+		}
+	}
+
+	// the third transfer for a client, and the fourth overall, are refused
+	if e := l.acquire(ctx, "10.0.0.1", 0, 2, 0); e == nil {
+		t.Error("the per client limit was exceeded")
+	}
+
+	if e := l.acquire(ctx, "10.0.0.3", 3, 0, 0); e == nil {
+		t.Error("the total limit was exceeded")
+	}
+
+	if msg, ok := tcore.TAssertInt("l.active()", l.active(), 3); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("10.0.0.1", l.activeByClient()["10.0.0.1"], 2); !ok {
+		t.Error(msg)
+	}
+
+	// a queued transfer starts when another ends
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.release("10.0.0.2")
+	}()
+
+	if e := l.acquire(ctx, "10.0.0.3", 3, 0, time.Second); e != nil {
+		t.Errorf("the queued transfer was refused: %s", e.Error())
+	}
+
+	counts := l.activeByClient()
+
+	if _, ok := counts["10.0.0.2"]; ok {
+		t.Error("a client with no transfers should not be listed")
+	}
+
+	// a queued transfer gives up when the server stops
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if e := l.acquire(canceled, "10.0.0.4", 3, 0, time.Second); e == nil {
+		t.Error("the queued transfer should give up when the context is done")
+	}
+}
+
+func TestLimiterClaim(t *testing.T) {
+	l := newLimiter()
+
+	if !l.claim("10.0.0.1:2000") {
+		t.Error("the first request from an address should be claimed")
+	}
+
+	if l.claim("10.0.0.1:2000") {
+		t.Error("a repeated request from an address should not be claimed")
+	}
+
+	if !l.claim("10.0.0.1:2001") {
+		t.Error("a request from another port should be claimed")
+	}
+
+	l.unclaim("10.0.0.1:2000")
+
+	if !l.claim("10.0.0.1:2000") {
+		t.Error("a request should be claimed once the last one has ended")
+	}
+}
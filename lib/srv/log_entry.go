@@ -19,7 +19,9 @@ type LogEntry struct {
 	Client   net.UDPAddr
 	Error    *cor.Err
 	File     string
-	Bytes    int
+	Bytes    int64
+	Retries  int    // the number of times the client failed to respond in time
+	Rule     string // the rule that refused the request, if one did
 }
 
 // String serializes the LogEntry to a string
@@ -32,11 +34,16 @@ func (l *LogEntry) String() string {
 		opName = "PUT"
 	}
 
-	baseInfoFormat := "%s, %s, %s"
-	baseInfo := fmt.Sprintf(baseInfoFormat, l.Start.Format("2006-01-02 15:04:05.000"), opName, l.Duration.String())
+	baseInfoFormat := "%s, %s, %s, %d retries"
+	baseInfo := fmt.Sprintf(baseInfoFormat, l.Start.Format("2006-01-02 15:04:05.000"), opName, l.Duration.String(), l.Retries)
 
 	if l.Error != nil {
 		errInfo := fmt.Sprintf("ERROR: %s", l.Error.Error())
+
+		if len(l.Rule) > 0 {
+			errInfo = fmt.Sprintf("%s, RULE: '%s'", errInfo, l.Rule)
+		}
+
 		return fmt.Sprintf("%s, %s", baseInfo, errInfo)
 	}
 
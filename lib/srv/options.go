@@ -0,0 +1,185 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// negotiate examines the mode and the options appended to the client's request (RFC 2347), setting the transfer
+// parameters of hndshk and recording the options the server accepts in hndshk.oack. Options the server does not
+// recognize are ignored, as the RFC requires. If the client sent a recognized option with a value the server cannot
+// honor, an ErrOption error is returned and the request should be refused. A mode other than netascii or octet is
+// refused with ErrBadOp.
+func (s *Server) negotiate(hndshk *handshake) *cor.Err {
+	hndshk.oack = nil
+	hndshk.blockSize = cor.BlockSize
+	hndshk.maxBytes = s.MaxFileSize
+	hndshk.timeout = s.Timeout
+	hndshk.retries = s.Retries
+	hndshk.windowSize = 1
+	hndshk.rollover = 0
+	hndshk.policy = s.WritePolicy
+
+	if s.Rollover == 1 {
+		hndshk.rollover = 1
+	}
+
+	if hndshk.timeout <= 0 {
+		hndshk.timeout = DefaultTimeout
+	}
+
+	if hndshk.retries < 0 {
+		hndshk.retries = 0
+	}
+
+	switch strings.ToLower(hndshk.tftpInfo.Mode) {
+	case cor.ModeOctet:
+		hndshk.netascii = false
+	case cor.ModeNetascii:
+		hndshk.netascii = true
+	case cor.ModeMail:
+		return cor.NewErr(cor.ErrBadOp, "mail mode is not supported")
+	default:
+		return cor.NewErrf(cor.ErrBadOp, "unknown mode '%s'", hndshk.tftpInfo.Mode)
+	}
+
+	for _, opt := range hndshk.tftpInfo.Options {
+		switch strings.ToLower(opt.Name) {
+		case cor.OptBlockSize:
+			blockSize, err := strconv.Atoi(opt.Value)
+
+			if err != nil || blockSize < cor.MinBlockSize {
+				return cor.NewErrf(cor.ErrOption, "invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			// the server may answer with a smaller block size than the client asked for, but never a larger one
+			if blockSize > s.maxBlockSize() {
+				blockSize = s.maxBlockSize()
+			}
+
+			hndshk.blockSize = blockSize
+			hndshk.oack.Set(cor.OptBlockSize, strconv.Itoa(blockSize))
+		case cor.OptTransferSize:
+			tsize, err := strconv.ParseInt(opt.Value, 10, 64)
+
+			if err != nil || tsize < 0 {
+				return cor.NewErrf(cor.ErrOption, "invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			if hndshk.tftpInfo.IsRRQ() && hndshk.netascii {
+				// the size of a file in netascii is not known until it has been translated, so like tftp-hpa we
+				// leave tsize out of the OACK rather than read the whole file twice
+				flog.Trace(fmt.Sprintf("ignoring %s in netascii mode", opt.Name))
+				continue
+			}
+
+			if hndshk.tftpInfo.IsWRQ() {
+				if e := s.checkSpace(hndshk.tftpInfo.Filename, tsize); e != nil {
+					return e
+				}
+			}
+
+			// for a WRQ we echo the client's size. for a RRQ, get replaces the value with the size of the file
+			hndshk.oack.Set(cor.OptTransferSize, strconv.FormatInt(tsize, 10))
+		case cor.OptTimeout:
+			seconds, err := strconv.Atoi(opt.Value)
+
+			if err != nil || seconds < cor.MinTimeout || seconds > cor.MaxTimeout {
+				return cor.NewErrf(cor.ErrOption, "invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			// RFC 2349 does not allow the server to counter with a different timeout, it is accepted as given
+			hndshk.timeout = time.Duration(seconds) * time.Second
+			hndshk.oack.Set(cor.OptTimeout, strconv.Itoa(seconds))
+		case cor.OptWindowSize:
+			windowSize, err := strconv.Atoi(opt.Value)
+
+			if err != nil || windowSize < cor.MinWindowSize || windowSize > cor.MaxWindowSize {
+				return cor.NewErrf(cor.ErrOption, "invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			// like blksize, the server may counter with a smaller window than the client asked for
+			if windowSize > s.maxWindowSize() {
+				windowSize = s.maxWindowSize()
+			}
+
+			hndshk.windowSize = windowSize
+			hndshk.oack.Set(cor.OptWindowSize, strconv.Itoa(windowSize))
+		case cor.OptRollover:
+			if opt.Value != "0" && opt.Value != "1" {
+				return cor.NewErrf(cor.ErrOption, "invalid %s '%s'", opt.Name, opt.Value)
+			}
+
+			hndshk.rollover = 0
+
+			if opt.Value == "1" {
+				hndshk.rollover = 1
+			}
+
+			hndshk.oack.Set(cor.OptRollover, opt.Value)
+		default:
+			// unrecognized options are left out of the OACK
+			flog.Trace(fmt.Sprintf("ignoring unrecognized option '%s'", opt.Name))
+		}
+	}
+
+	return nil
+}
+
+// checkSpace returns an ErrDisk error if a file of size bytes exceeds MaxFileSize or the store's remaining capacity
+// for the named file
+func (s *Server) checkSpace(name string, size int64) *cor.Err {
+	if s.MaxFileSize > 0 && size > s.MaxFileSize {
+		return cor.NewErrf(cor.ErrDisk, "the file size %d exceeds the limit of %d bytes", size, s.MaxFileSize)
+	}
+
+	if remaining := stor.Remaining(s.store, name); size > remaining {
+		return cor.NewErrf(cor.ErrDisk, "the file size %d exceeds the remaining capacity of %d bytes", size, remaining)
+	}
+
+	return nil
+}
+
+// maxBlockSize returns s.MaxBlockSize bounded to the range allowed by RFC 2348
+func (s *Server) maxBlockSize() int {
+	if s.MaxBlockSize < cor.MinBlockSize {
+		return cor.BlockSize
+	} else if s.MaxBlockSize > cor.MaxBlockSize {
+		return cor.MaxBlockSize
+	}
+
+	return s.MaxBlockSize
+}
+
+// sendOAck sends an option acknowledgement carrying the accepted options
+func sendOAck(conn *net.UDPConn, options cor.Options) error {
+	oack := cor.PacketOAck{}
+	oack.Options = options
+	_, err := conn.Write(oack.Serialize())
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// maxWindowSize returns s.MaxWindowSize bounded to the range allowed by RFC 7440
+func (s *Server) maxWindowSize() int {
+	if s.MaxWindowSize < cor.MinWindowSize {
+		return cor.MinWindowSize
+	} else if s.MaxWindowSize > cor.MaxWindowSize {
+		return cor.MaxWindowSize
+	}
+
+	return s.MaxWindowSize
+}
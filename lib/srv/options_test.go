@@ -0,0 +1,563 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/stor"
+)
+
+func TestNegotiateIgnoresUnknownOptions(t *testing.T) {
+	h := handshake{}
+	h.tftpInfo.OpCode = cor.OpRRQ
+	h.tftpInfo.Mode = cor.ModeOctet
+	h.tftpInfo.Options = cor.Options{{Name: "fnord", Value: "1"}, {Name: "frobnicate", Value: "yes"}}
+	server := NewServer(stor.NewMemStore())
+	e := server.negotiate(&h)
+
+	if e != nil {
+		t.Error(e.Error())
+	}
+
+	stm := "len(h.oack)"
+	gotI := len(h.oack)
+	wantI := 0
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestNegotiateBlockSize(t *testing.T) {
+	server := NewServer(stor.NewMemStore())
+	server.MaxBlockSize = 1024
+
+	tests := []struct {
+		value     string
+		blockSize int
+		ok        bool
+	}{
+		{"", cor.BlockSize, true},
+		{"8", 8, true},
+		{"1000", 1000, true},
+		{"1024", 1024, true},
+		{"1428", 1024, true},
+		{"65464", 1024, true},
+		{"7", 0, false},
+		{"0", 0, false},
+		{"-512", 0, false},
+		{"big", 0, false},
+	}
+
+	for _, test := range tests {
+		h := handshake{}
+		h.tftpInfo.OpCode = cor.OpRRQ
+		h.tftpInfo.Mode = cor.ModeOctet
+
+		if len(test.value) > 0 {
+			h.tftpInfo.Options = cor.Options{{Name: "BLKSIZE", Value: test.value}}
+		}
+
+		e := server.negotiate(&h)
+
+		if !test.ok {
+			if e == nil {
+				t.Errorf("blksize '%s' should have been refused", test.value)
+			} else if msg, ok := tcore.TAssertInt("e.Code()", int(e.Code()), int(cor.ErrOption)); !ok {
+				t.Error(msg)
+			}
+
+			continue
+		}
+
+		if e != nil {
+			t.Errorf("blksize '%s' should have been accepted: %s", test.value, e.Error())
+			continue
+		}
+
+		stm := "h.blockSize"
+		gotI := h.blockSize
+		wantI := test.blockSize
+		if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+			t.Error(msg)
+		}
+	}
+}
+
+func TestGetWithBlockSize(t *testing.T) {
+	file := cor.File{Name: "blksize-get.bin", Data: makeTestData(5000)}
+	_, stop := startTestServer(t, 11113, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11113)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"}
+	rrq.Options = cor.Options{{Name: cor.OptBlockSize, Value: "1000"}}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	oack, ok := packet.(*cor.PacketOAck)
+
+	if !ok {
+		t.Fatalf("want an oack packet, got op %s", packet.Op().String())
+	}
+
+	val, _ := oack.Options.Get(cor.OptBlockSize)
+
+	if msg, ok := tcore.TAssertString("oack blksize", val, "1000"); !ok {
+		t.Error(msg)
+	}
+
+	// 5000 bytes in 1000 byte blocks is five full blocks followed by an empty one
+	got := make([]byte, 0)
+	ack := cor.PacketAck{BlockNum: 0}
+
+	for blk := 1; blk <= 6; blk++ {
+		if err = client.send(&ack); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		packet, err = client.receive()
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		data, ok := packet.(*cor.PacketData)
+
+		if !ok {
+			t.Fatalf("want a data packet, got op %s", packet.Op().String())
+		}
+
+		if msg, ok := tcore.TAssertInt("data.BlockNum", int(data.BlockNum), blk); !ok {
+			t.Fatal(msg)
+		}
+
+		got = append(got, data.Data...)
+		ack.BlockNum = data.BlockNum
+	}
+
+	_ = client.send(&ack)
+
+	if !bytes.Equal(got, file.Data) {
+		t.Error("the received data does not match the file")
+	}
+}
+
+func TestPutWithBlockSize(t *testing.T) {
+	server, stop := startTestServer(t, 11114, nil)
+	defer stop()
+
+	client, err := newFakeClient(11114)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	testFile := makeTestData(3000)
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "blksize-put.bin", Mode: "octet"}
+	wrq.Options = cor.Options{{Name: cor.OptBlockSize, Value: "2048"}}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if !packet.IsOAck() {
+		t.Fatalf("want an oack packet, got op %s", packet.Op().String())
+	}
+
+	// the default MaxBlockSize clamps the client's request
+	blockSize := TftpMaxPacketSize
+	blk := uint16(1)
+
+	for pos := 0; pos <= len(testFile); pos += blockSize {
+		end := pos + blockSize
+
+		if end > len(testFile) {
+			end = len(testFile)
+		}
+
+		if err = client.send(&cor.PacketData{BlockNum: blk, Data: testFile[pos:end]}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		packet, err = client.receive()
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		ack, ok := packet.(*cor.PacketAck)
+
+		if !ok {
+			t.Fatalf("want an ack packet, got op %s", packet.Op().String())
+		}
+
+		if msg, ok := tcore.TAssertInt("ack.BlockNum", int(ack.BlockNum), int(blk)); !ok {
+			t.Fatal(msg)
+		}
+
+		blk++
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, wrq.Filename, testFile)
+}
+
+func TestNegotiateTransferSize(t *testing.T) {
+	server := NewServer(stor.NewMemStore())
+	server.MaxFileSize = 1000
+
+	tests := []struct {
+		op    cor.OpType
+		value string
+		code  cor.ErrCode
+		ok    bool
+	}{
+		{cor.OpRRQ, "0", 0, true},
+		{cor.OpRRQ, "5000", 0, true},
+		{cor.OpWRQ, "1000", 0, true},
+		{cor.OpWRQ, "1001", cor.ErrDisk, false},
+		{cor.OpWRQ, "-1", cor.ErrOption, false},
+		{cor.OpRRQ, "zero", cor.ErrOption, false},
+	}
+
+	for _, test := range tests {
+		h := handshake{}
+		h.tftpInfo.OpCode = test.op
+		h.tftpInfo.Mode = cor.ModeOctet
+		h.tftpInfo.Options = cor.Options{{Name: cor.OptTransferSize, Value: test.value}}
+		e := server.negotiate(&h)
+
+		if !test.ok {
+			if e == nil {
+				t.Errorf("%s tsize '%s' should have been refused", test.op.String(), test.value)
+			} else if msg, ok := tcore.TAssertInt("e.Code()", int(e.Code()), int(test.code)); !ok {
+				t.Error(msg)
+			}
+
+			continue
+		}
+
+		if e != nil {
+			t.Errorf("%s tsize '%s' should have been accepted: %s", test.op.String(), test.value, e.Error())
+			continue
+		}
+
+		val, _ := h.oack.Get(cor.OptTransferSize)
+
+		if msg, ok := tcore.TAssertString("oack tsize", val, test.value); !ok {
+			t.Error(msg)
+		}
+	}
+}
+
+func TestNegotiateTransferSizeMounted(t *testing.T) {
+	// the budget of a mounted store is checked for the files under its prefix
+	mounts, err := stor.NewMountStore([]stor.Mount{
+		{Prefix: "upload", Store: stor.NewMemStore(stor.WithBudget(1000))},
+		{Prefix: "big", Store: stor.NewMemStore()},
+	})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	server := NewServer(stor.NewOverlayStore([]stor.Store{mounts}))
+
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"upload/a.bin", "1000", true},
+		{"upload/a.bin", "1001", false},
+		{"big/a.bin", "1001", true},
+	}
+
+	for _, test := range tests {
+		h := handshake{}
+		h.tftpInfo.OpCode = cor.OpWRQ
+		h.tftpInfo.Filename = test.name
+		h.tftpInfo.Mode = cor.ModeOctet
+		h.tftpInfo.Options = cor.Options{{Name: cor.OptTransferSize, Value: test.value}}
+		e := server.negotiate(&h)
+
+		if test.ok && e != nil {
+			t.Errorf("%s of %s bytes should have been accepted: %s", test.name, test.value, e.Error())
+		} else if !test.ok && (e == nil || e.Code() != cor.ErrDisk) {
+			t.Errorf("%s of %s bytes should have been refused with a disk full error", test.name, test.value)
+		}
+	}
+}
+
+func TestNegotiateTimeout(t *testing.T) {
+	server := NewServer(stor.NewMemStore())
+	server.Timeout = 7 * time.Second
+
+	tests := []struct {
+		value   string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"", 7 * time.Second, true},
+		{"1", time.Second, true},
+		{"255", 255 * time.Second, true},
+		{"0", 0, false},
+		{"256", 0, false},
+		{"1.5", 0, false},
+	}
+
+	for _, test := range tests {
+		h := handshake{}
+		h.tftpInfo.OpCode = cor.OpWRQ
+		h.tftpInfo.Mode = cor.ModeOctet
+
+		if len(test.value) > 0 {
+			h.tftpInfo.Options = cor.Options{{Name: cor.OptTimeout, Value: test.value}}
+		}
+
+		e := server.negotiate(&h)
+
+		if !test.ok {
+			if e == nil {
+				t.Errorf("timeout '%s' should have been refused", test.value)
+			}
+
+			continue
+		}
+
+		if e != nil {
+			t.Errorf("timeout '%s' should have been accepted: %s", test.value, e.Error())
+			continue
+		}
+
+		stm := "h.timeout"
+		gotS := h.timeout.String()
+		wantS := test.timeout.String()
+		if msg, ok := tcore.TAssertString(stm, gotS, wantS); !ok {
+			t.Error(msg)
+		}
+
+		val, _ := h.oack.Get(cor.OptTimeout)
+
+		if msg, ok := tcore.TAssertString("oack timeout", val, test.value); !ok {
+			t.Error(msg)
+		}
+	}
+}
+
+func TestGetTransferSize(t *testing.T) {
+	file := cor.File{Name: "tsize-get.bin", Data: makeTestData(1234)}
+	_, stop := startTestServer(t, 11115, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11115)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"}
+	rrq.Options = cor.Options{{Name: cor.OptTransferSize, Value: "0"}}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	oack, ok := packet.(*cor.PacketOAck)
+
+	if !ok {
+		t.Fatalf("want an oack packet, got op %s", packet.Op().String())
+	}
+
+	val, _ := oack.Options.Get(cor.OptTransferSize)
+
+	if msg, ok := tcore.TAssertString("oack tsize", val, "1234"); !ok {
+		t.Error(msg)
+	}
+
+	// abandon the transfer
+	_ = client.send(&cor.PacketError{Code: cor.ErrUnknown, Msg: "done"})
+}
+
+func TestPutTransferSizeTooLarge(t *testing.T) {
+	_, stop := startTestServer(t, 11116, func(s *Server) { s.MaxFileSize = 100 })
+	defer stop()
+
+	client, err := newFakeClient(11116)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "tsize-put.bin", Mode: "octet"}
+	wrq.Options = cor.Options{{Name: cor.OptTransferSize, Value: "101"}}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	pktErr, ok := packet.(*cor.PacketError)
+
+	if !ok {
+		t.Fatalf("want an error packet, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("pktErr.Code", int(pktErr.Code), int(cor.ErrDisk)); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetWithUnknownOptions(t *testing.T) {
+	file := cor.File{Name: "unknown-options.bin", Data: makeTestData(100)}
+	_, stop := startTestServer(t, 11112, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11112)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	rrq := cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"}
+	rrq.Options = cor.Options{{Name: "fnord", Value: "1"}}
+
+	if err = client.send(&rrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// with no recognized options there is no OACK, the first packet is data block 1
+	packet, err := client.receive()
+
+	if msg, ok := tcore.TErr("packet, err := client.receive()", err); !ok {
+		t.Fatal(msg)
+	}
+
+	data, ok := packet.(*cor.PacketData)
+
+	if !ok {
+		t.Fatalf("want a data packet, got op %s", packet.Op().String())
+	}
+
+	stm := "data.BlockNum"
+	gotI := int(data.BlockNum)
+	wantI := 1
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+
+	stm = "len(data.Data)"
+	gotI = len(data.Data)
+	wantI = len(file.Data)
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+
+	_ = client.send(&cor.PacketAck{BlockNum: 1})
+}
+
+func TestNegotiateMode(t *testing.T) {
+	server := NewServer(stor.NewMemStore())
+
+	tests := []struct {
+		mode     string
+		netascii bool
+		ok       bool
+	}{
+		{"octet", false, true},
+		{"OCTET", false, true},
+		{"netascii", true, true},
+		{"NetASCII", true, true},
+		{"mail", false, false},
+		{"binary", false, false},
+		{"", false, false},
+	}
+
+	for _, test := range tests {
+		h := handshake{}
+		h.tftpInfo.OpCode = cor.OpRRQ
+		h.tftpInfo.Mode = test.mode
+		e := server.negotiate(&h)
+
+		if !test.ok {
+			if e == nil {
+				t.Errorf("mode '%s' should have been refused", test.mode)
+			} else if msg, ok := tcore.TAssertInt("e.Code()", int(e.Code()), int(cor.ErrBadOp)); !ok {
+				t.Error(msg)
+			}
+
+			continue
+		}
+
+		if e != nil {
+			t.Errorf("mode '%s' should have been accepted: %s", test.mode, e.Error())
+			continue
+		}
+
+		if h.netascii != test.netascii {
+			t.Errorf("mode '%s': netascii got %t, want %t", test.mode, h.netascii, test.netascii)
+		}
+	}
+}
+
+func TestNegotiateNetasciiTransferSize(t *testing.T) {
+	// the netascii size of a file is not known in advance, so tsize is left out of the OACK for a netascii read
+	h := handshake{}
+	h.tftpInfo.OpCode = cor.OpRRQ
+	h.tftpInfo.Mode = cor.ModeNetascii
+	h.tftpInfo.Options = cor.Options{{Name: cor.OptTransferSize, Value: "0"}}
+	server := NewServer(stor.NewMemStore())
+
+	if e := server.negotiate(&h); e != nil {
+		t.Fatal(e.Error())
+	}
+
+	if _, ok := h.oack.Get(cor.OptTransferSize); ok {
+		t.Error("tsize should not be acknowledged in netascii mode")
+	}
+}
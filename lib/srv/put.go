@@ -3,6 +3,7 @@
 package srv
 
 import (
+	"errors"
 	"io"
 	"net"
 	"sync"
@@ -20,76 +21,204 @@ var packetPool = sync.Pool{
 	},
 }
 
-func put(hndshk handshake, store stor.Store) (conn *net.UDPConn, numBytes int, err error) {
+// getPacketBuf returns a zeroed buffer large enough to hold a DATA packet carrying blockSize bytes. Buffers for the
+// common block sizes come from packetPool and should be handed back with putPacketBuf.
+func getPacketBuf(blockSize int) []byte {
+	if blockSize+4 > cor.MaxPacketSize {
+		return make([]byte, blockSize+4)
+	}
+
+	buf := packetPool.Get().([]byte)
+	memset(buf)
+	return buf
+}
+
+// putPacketBuf returns a buffer obtained from getPacketBuf to packetPool
+func putPacketBuf(buf []byte) {
+	if len(buf) == cor.MaxPacketSize {
+		packetPool.Put(buf)
+	}
+}
+
+// nopCloser adds a Close method that does nothing to an io.Writer
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func put(hndshk handshake, store stor.Store) (conn *net.UDPConn, stats transferStats, err error) {
 	conn, err = net.DialUDP("udp", &hndshk.server, &hndshk.client)
 
 	if err != nil {
-		return nil, 0, flog.Wrap(err)
+		return nil, stats, flog.Wrap(err)
+	}
+
+	defer hndshk.watchCancel(conn)()
+
+	// the policy, and then the store, may refuse the file before the client is acknowledged
+	if e := checkWrite(hndshk.policy, store, hndshk.tftpInfo.Filename); e != nil {
+		return conn, stats, e
 	}
 
-	theFile := cor.File{}
-	theFile.Name = hndshk.tftpInfo.Filename
-	theFile.Data = make([]byte, 0)
+	// the file is created before the client is acknowledged, so that a name the store refuses is refused up front
+	file, err := stor.AsStreamer(store).Create(hndshk.tftpInfo.Filename)
 
-	if err := sendHandshakeAck(conn); err != nil {
-		return conn, 0, flog.Wrap(err)
+	if err != nil {
+		return conn, stats, err
+	}
+
+	// discards the file unless it is committed below
+	defer func() { _ = file.Close() }()
+
+	// received blocks are written to sink, which translates them from netascii when needed
+	data := &countingWriter{w: file}
+	var sink io.WriteCloser = nopCloser{data}
+
+	if hndshk.netascii {
+		sink = cor.NewNetasciiWriter(data)
 	}
 
 	// block 0 is the acknowledgement, block 1 is the first data block
-	blk := 1
+	var blk uint64 = 1
+
+	// ackPrevious acknowledges the block before blk. An OACK takes the place of the block 0 acknowledgement when options
+	// were negotiated, and is sent again until block 1 arrives, since an ACK of block 0 would tell the client that its
+	// options were refused (RFC 2347) and it would fall back to the defaults.
+	ackPrevious := func() error {
+		if blk == 1 && len(hndshk.oack) > 0 {
+			return sendOAck(conn, hndshk.oack)
+		}
 
-	buf := packetPool.Get().([]byte)
-	memset(buf)
-	defer packetPool.Put(buf)
+		return sendAck(conn, hndshk.wireBlock(blk-1))
+	}
+
+	if err = ackPrevious(); err != nil {
+		return conn, stats, flog.Wrap(err)
+	}
+
+	// the number of blocks received since the last acknowledgement. with a window size greater than one, the client
+	// sends a whole window of blocks before expecting an acknowledgement (RFC 7440)
+	unacked := 0
+
+	// true when we have told the client about a lost block and are waiting for it to resend the window
+	lossReported := false
+
+	buf := getPacketBuf(hndshk.blockSize)
+	defer putPacketBuf(buf)
 
-dataLoop:
 	for {
-		n, raddr, err := readWithRetry(conn, 3, buf, blk)
+		n, raddr, timeouts, err := readWithRetry(conn, &hndshk, buf, ackPrevious)
+		stats.retries += timeouts
 
 		if err != nil {
-			return conn, 0, err
+			return conn, stats, err
 		}
 
 		packet, err := cor.ParsePacket(buf[:n])
 
 		if err != nil {
-			return conn, 0, err
+			return conn, stats, err
 		}
 
 		// check a bunch of possible error conditions
 		err = verifyDataPacket(packet, hndshk, raddr)
 
 		if err != nil {
-			return conn, 0, err
+			return conn, stats, err
+		}
+
+		chunk, err := handleData(packet, hndshk.wireBlock(blk), hndshk.blockSize)
+
+		if err == errOutOfOrder {
+			// a duplicate block means our acknowledgement was lost, and a block from further ahead means a block in the
+			// window was lost. either way, acknowledging the last block we have tells the client where to resume. in
+			// lock-step every duplicate is acknowledged, in a window once is enough until the client catches up
+			if hndshk.windowSize == 1 || !lossReported {
+				lossReported = true
+				unacked = 0
+
+				if err = ackPrevious(); err != nil {
+					return conn, stats, flog.Raisef("acknowledgement could not be sent %s", err.Error())
+				}
+			}
+
+			continue
+		} else if err != nil && err != io.EOF {
+			return conn, stats, err
+		}
+
+		isLast := err == io.EOF
+		lossReported = false
+		unacked++
+
+		if _, err = sink.Write(chunk); err == nil && isLast {
+			err = sink.Close()
+		}
+
+		if err != nil {
+			return conn, stats, err
+		}
+
+		if hndshk.maxBytes > 0 && data.n > hndshk.maxBytes {
+			return conn, stats, cor.NewErrf(cor.ErrDisk, "the file exceeds the limit of %d bytes", hndshk.maxBytes)
 		}
 
-		chunk, err := handleData(conn, packet, blk)
-		theFile.Data = append(theFile.Data, chunk...)
+		// the file is stored before the final acknowledgement, so that the client hears about a failure to store it.
+		// another client may have stored a file by the same name during the transfer, so the policy is checked again
+		if isLast {
+			if e := checkWrite(hndshk.policy, store, hndshk.tftpInfo.Filename); e != nil {
+				return conn, stats, e
+			}
 
-		if err == io.EOF {
-			break dataLoop
+			if err = file.Commit(); err != nil {
+				return conn, stats, err
+			}
+		}
+
+		// an upload is paced by holding back the acknowledgements that let the client send more
+		if err = hndshk.pace(len(chunk)); err != nil {
+			return conn, stats, err
+		}
+
+		if isLast || unacked >= hndshk.windowSize {
+			unacked = 0
+
+			if err = sendAck(conn, hndshk.wireBlock(blk)); err != nil {
+				return conn, stats, flog.Raisef("acknowledgement could not be sent %s", err.Error())
+			}
+		}
+
+		if isLast {
+			break
 		}
 
 		blk++
 	}
 
-	numBytes = len(theFile.Data)
-	err = store.Put(theFile)
+	stats.numBytes = data.n
+	return conn, stats, nil
+}
 
-	if err != nil {
-		return conn, 0, err
-	}
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	return conn, numBytes, nil
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
 
 func sendHandshakeAck(conn *net.UDPConn) error {
 	return sendAck(conn, 0)
 }
 
-func sendAck(conn *net.UDPConn, block int) error {
+func sendAck(conn *net.UDPConn, block uint16) error {
 	ack := cor.PacketAck{}
-	ack.BlockNum = uint16(block)
+	ack.BlockNum = block
 	_, err := conn.Write(ack.Serialize())
 
 	if err != nil {
@@ -112,19 +241,29 @@ func sendErr(conn *net.UDPConn, code cor.ErrCode, message string) error {
 	return nil
 }
 
-func readWithRetry(conn *net.UDPConn, retries int, ioBuf []byte, lastSuccessfulBlock int) (numBytes int, raddr *net.UDPAddr, err error) {
-	for retryCount := 0; retryCount <= retries; retryCount++ {
-		err := conn.SetReadDeadline(time.Now().Add(timeout))
+// readWithRetry reads the next packet from the client. Each time the client fails to send anything within the
+// transfer's timeout, resend acknowledges the last block received again to prompt the client to resend, up to the
+// transfer's retries. It gives up when the client fails to respond after the last of them, or as soon as the server
+// abandons the transfer. The number of times the client failed to respond in time is returned along with the packet.
+func readWithRetry(conn *net.UDPConn, hndshk *handshake, ioBuf []byte, resend func() error) (numBytes int, raddr *net.UDPAddr, timeouts int, err error) {
+	retries := hndshk.retries
+
+	for timeouts = 0; timeouts <= retries; timeouts++ {
+		err = conn.SetReadDeadline(time.Now().Add(hndshk.timeout))
 
 		if err != nil {
-			return 0, nil, err
+			return 0, nil, timeouts, err
+		}
+
+		if err = hndshk.canceled(); err != nil {
+			return 0, nil, timeouts, err
 		}
 
 		numBytes, raddr, err = conn.ReadFromUDP(ioBuf)
 
 		if err == nil {
 			// no error - return the results
-			return numBytes, raddr, err
+			return numBytes, raddr, timeouts, err
 		}
 
 		// an error condition exists, check if we can downcast it to net.Error
@@ -132,53 +271,56 @@ func readWithRetry(conn *net.UDPConn, retries int, ioBuf []byte, lastSuccessfulB
 
 		if !ok {
 			// this is not a net.Error - terminate and notify the client that things are bad
-			_ = sendError(conn, err)
-			return numBytes, raddr, flog.Wrap(err)
+			return numBytes, raddr, timeouts, flog.Wrap(err)
 		}
 
-		if !netErr.Timeout() && !netErr.Temporary() {
+		if !netErr.Timeout() {
 			// this is not a recoverable error - terminate and notify the client things are bad
-			_ = sendError(conn, err)
-			return numBytes, raddr, flog.Wrap(netErr)
+			return numBytes, raddr, timeouts, flog.Wrap(netErr)
 		}
 
-		// notify the client that we want to retry
-		err = sendAck(conn, lastSuccessfulBlock)
+		// the deadline may have been cut short because the transfer was abandoned
+		if err = hndshk.canceled(); err != nil {
+			return numBytes, raddr, timeouts, err
+		}
 
-		if err != nil {
+		// the retries are used up, there is nothing left to wait for
+		if timeouts == retries {
+			continue
+		}
+
+		// notify the client that we want to retry
+		if err = resend(); err != nil {
 			// unable to communicate with the client - bail out
-			err = flog.Raisef("lost communication with client: %s", err.Error())
-			_ = sendError(conn, err)
-			return numBytes, raddr, err
+			return numBytes, raddr, timeouts, flog.Raisef("lost communication with client: %s", err.Error())
 		}
 	}
 
-	err = flog.Raisef("tried connecting %d time(s) without success: %s", err.Error())
-	_ = sendError(conn, err)
-	return numBytes, raddr, err
+	err = cor.NewErrf(cor.ErrUnknown, "the next block was not received after %d retries", retries)
+	return numBytes, raddr, timeouts, err
 }
 
-func handleData(conn *net.UDPConn, packet cor.Packet, expectedBlock int) ([]byte, error) {
+// errOutOfOrder is returned by handleData when a data packet does not carry the expected block
+var errOutOfOrder = errors.New("data block out of order")
+
+// handleData returns a copy of the data carried by packet. It returns errOutOfOrder if the packet is not expectedBlock,
+// and io.EOF if it is the final block of the transfer.
+func handleData(packet cor.Packet, expectedBlock uint16, blockSize int) ([]byte, error) {
 	dataPacket, ok := packet.(*cor.PacketData)
 
 	if !ok {
 		return nil, flog.Raise("the packet is not a data packet")
 	}
 
-	if dataPacket.BlockNum != uint16(expectedBlock) {
-		return nil, flog.Raisef("wrong block num, got %d, want %d", dataPacket.BlockNum, expectedBlock)
+	if dataPacket.BlockNum != expectedBlock {
+		return nil, errOutOfOrder
 	}
 
 	copied := make([]byte, len(dataPacket.Data))
 	copy(copied, dataPacket.Data)
-	err := sendAck(conn, expectedBlock)
-
-	if err != nil {
-		return nil, flog.Raisef("acknowledgement could not be sent %s", err.Error())
-	}
 
 	// check if this is the last received data packet
-	if len(dataPacket.Data) < cor.BlockSize {
+	if len(dataPacket.Data) < blockSize {
 		return copied, io.EOF
 	}
 
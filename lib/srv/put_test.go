@@ -3,9 +3,12 @@
 package srv
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"net"
+	"os"
 	"sync"
 	"testing"
 	"time"
@@ -159,11 +162,7 @@ func callPutFunctionOnServerAsync(client *net.UDPAddr, server *net.UDPAddr, file
 }
 
 func callPutFunctionOnServer(client *net.UDPAddr, server *net.UDPAddr, filename string, memStore stor.Store, wg *sync.WaitGroup) {
-	h := handshake{}
-	h.client = *client
-	h.server = *server
-	h.tftpInfo.OpCode = cor.OpWRQ
-	h.tftpInfo.Filename = filename
+	h := makeTestHandshake(client, server, cor.OpWRQ, filename)
 
 	_, _, err := put(h, memStore)
 
@@ -174,6 +173,21 @@ func callPutFunctionOnServer(client *net.UDPAddr, server *net.UDPAddr, filename
 	wg.Done()
 }
 
+// makeTestHandshake creates a handshake with the server's default transfer parameters
+func makeTestHandshake(client *net.UDPAddr, server *net.UDPAddr, op cor.OpType, filename string) handshake {
+	h := handshake{}
+	h.client = *client
+	h.server = *server
+	h.tftpInfo.OpCode = op
+	h.tftpInfo.Filename = filename
+	h.blockSize = cor.BlockSize
+	h.timeout = DefaultTimeout
+	h.retries = DefaultRetries
+	h.windowSize = 1
+	h.rollover = 0
+	return h
+}
+
 func sendData(testFile []byte, clientConn *net.UDPConn, err error) error {
 	sendEmptyAtEnd := false
 	blk := 1
@@ -249,3 +263,491 @@ func doPutTestAssertions(t *testing.T, err error, memStore stor.Store, filename
 		}
 	}
 }
+
+func TestPutTooLarge(t *testing.T) {
+	var client, _ = net.ResolveUDPAddr("udp", ":12986")
+	var server, _ = net.ResolveUDPAddr("udp", ":21986")
+	filename := "toolarge.zip"
+	clientConn, err := net.DialUDP("udp", client, server)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = clientConn.Close() }()
+	memStore := stor.NewMemStore()
+	h := makeTestHandshake(client, server, cor.OpWRQ, filename)
+	h.maxBytes = 1000
+	errChan := make(chan error, 1)
+
+	go func() {
+		_, _, err := put(h, memStore)
+		errChan <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	_ = sendData(makeTestData(3671), clientConn, nil)
+	err = <-errChan
+
+	e, ok := err.(*cor.Err)
+
+	if !ok {
+		t.Fatalf("want a *cor.Err, got %v", err)
+	}
+
+	if msg, ok := tcore.TAssertInt("e.Code()", int(e.Code()), int(cor.ErrDisk)); !ok {
+		t.Error(msg)
+	}
+
+	if _, err = memStore.Get(filename); err == nil {
+		t.Error("the file should not have been stored")
+	}
+}
+
+// startPut sends a WRQ with options and waits for the server's OACK
+func startPut(t *testing.T, client *fakeClient, filename string, options cor.Options) {
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: filename, Mode: "octet", Options: options}
+
+	if err := client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !packet.IsOAck() {
+		t.Fatalf("want an oack packet, got op %s", packet.Op().String())
+	}
+}
+
+// receiveAck waits for an acknowledgement and fails the test if it is not for blk
+func receiveAck(t *testing.T, client *fakeClient, blk int) {
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ack, ok := packet.(*cor.PacketAck)
+
+	if !ok {
+		t.Fatalf("want an ack packet, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("ack.BlockNum", int(ack.BlockNum), blk); !ok {
+		t.Fatal(msg)
+	}
+}
+
+func TestPutWindowSize(t *testing.T) {
+	server, stop := startTestServer(t, 11119, nil)
+	defer stop()
+
+	client, err := newFakeClient(11119)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	// nine full blocks and a short one
+	testFile := makeTestData(9*cor.BlockSize + 10)
+	filename := "window-put.bin"
+	startPut(t, client, filename, cor.Options{{Name: cor.OptWindowSize, Value: "4"}})
+	acks := 0
+
+	for blk := 1; blk <= 10; blk++ {
+		pos := (blk - 1) * cor.BlockSize
+		end := pos + cor.BlockSize
+
+		if end > len(testFile) {
+			end = len(testFile)
+		}
+
+		if err = client.send(&cor.PacketData{BlockNum: uint16(blk), Data: testFile[pos:end]}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		// the server acknowledges every fourth block, and the last
+		if blk%4 == 0 || blk == 10 {
+			receiveAck(t, client, blk)
+			acks++
+		}
+	}
+
+	if msg, ok := tcore.TAssertInt("acks", acks, 3); !ok {
+		t.Error(msg)
+	}
+
+	// nothing else should have been sent
+	if packet, err := client.receiveWithin(100 * time.Millisecond); err == nil {
+		t.Errorf("unexpected packet, op %s", packet.Op().String())
+	}
+
+	doPutTestAssertions(t, nil, server.store, filename, testFile)
+}
+
+func TestPutWindowSizeLoss(t *testing.T) {
+	server, stop := startTestServer(t, 11120, nil)
+	defer stop()
+
+	client, err := newFakeClient(11120)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	testFile := makeTestData(7*cor.BlockSize + 10)
+	filename := "window-put-loss.bin"
+	startPut(t, client, filename, cor.Options{{Name: cor.OptWindowSize, Value: "4"}})
+
+	block := func(blk int) *cor.PacketData {
+		pos := (blk - 1) * cor.BlockSize
+		end := pos + cor.BlockSize
+
+		if end > len(testFile) {
+			end = len(testFile)
+		}
+
+		return &cor.PacketData{BlockNum: uint16(blk), Data: testFile[pos:end]}
+	}
+
+	// block 3 goes missing, the server acknowledges block 2 exactly once
+	for _, blk := range []int{1, 2, 4} {
+		_ = client.send(block(blk))
+	}
+
+	receiveAck(t, client, 2)
+
+	for blk := 3; blk <= 8; blk++ {
+		_ = client.send(block(blk))
+
+		if blk == 6 || blk == 8 {
+			receiveAck(t, client, blk)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, filename, testFile)
+}
+
+func TestPutGivesUp(t *testing.T) {
+	client, err := newFakeClient(0)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	store := stor.NewMemStore()
+	server, _ := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	h := makeTestHandshake(client.conn.LocalAddr().(*net.UDPAddr), server, cor.OpWRQ, "givesup.bin")
+	h.timeout = 50 * time.Millisecond
+	h.retries = 2
+	lch := make(chan LogEntry, 1)
+	go doAsyncTransfer(h, store, LogEntry{Start: time.Now()}, lch, put)
+
+	// the first acknowledgement and two retries
+	for i := 0; i < 3; i++ {
+		receiveAck(t, client, 0)
+	}
+
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !packet.IsError() {
+		t.Fatalf("want an error packet, got op %s", packet.Op().String())
+	}
+
+	l := <-lch
+
+	if l.Error == nil {
+		t.Error("the log entry should have an error")
+	}
+
+	if msg, ok := tcore.TAssertInt("l.Retries", l.Retries, 3); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestPutLostOAck(t *testing.T) {
+	server, stop := startTestServer(t, 11143, func(s *Server) { s.Timeout = 200 * time.Millisecond })
+	defer stop()
+
+	client, err := newFakeClient(11143)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	testFile := makeTestData(1024 + 10)
+	filename := "lost-oack.bin"
+
+	// the first OACK is lost, the server must send the OACK again rather than an ACK of block 0, which would tell the
+	// client that its blksize was refused
+	startPut(t, client, filename, cor.Options{{Name: cor.OptBlockSize, Value: "1024"}})
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !packet.IsOAck() {
+		t.Fatalf("want the oack again, got op %s", packet.Op().String())
+	}
+
+	_ = client.send(&cor.PacketData{BlockNum: 1, Data: testFile[:1024]})
+	receiveAck(t, client, 1)
+	_ = client.send(&cor.PacketData{BlockNum: 2, Data: testFile[1024:]})
+	receiveAck(t, client, 2)
+	doPutTestAssertions(t, nil, server.store, filename, testFile)
+}
+
+func TestPutDuplicateData(t *testing.T) {
+	server, stop := startTestServer(t, 11122, nil)
+	defer stop()
+
+	client, err := newFakeClient(11122)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	testFile := makeTestData(cor.BlockSize + 10)
+	filename := "duplicate-put.bin"
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: filename, Mode: "octet"}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	receiveAck(t, client, 0)
+	first := &cor.PacketData{BlockNum: 1, Data: testFile[:cor.BlockSize]}
+	_ = client.send(first)
+	receiveAck(t, client, 1)
+
+	// pretend our acknowledgement was lost and send the block again, the server acknowledges it again
+	_ = client.send(first)
+	receiveAck(t, client, 1)
+
+	_ = client.send(&cor.PacketData{BlockNum: 2, Data: testFile[cor.BlockSize:]})
+	receiveAck(t, client, 2)
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, filename, testFile)
+}
+
+func TestPutRollover(t *testing.T) {
+	const numBlocks = 65540
+	server, stop := startTestServer(t, 11125, func(s *Server) { s.Rollover = 1 })
+	defer stop()
+
+	client, err := newFakeClient(11125)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	testFile := makeTestData(numBlocks*8 - 3)
+	filename := "rollover-put.bin"
+	options := cor.Options{{Name: cor.OptBlockSize, Value: "8"}, {Name: cor.OptWindowSize, Value: "64"}}
+	startPut(t, client, filename, options)
+	h := handshake{rollover: 1}
+
+	for blk := uint64(1); blk <= numBlocks; blk++ {
+		pos := (blk - 1) * 8
+		end := pos + 8
+
+		if end > uint64(len(testFile)) {
+			end = uint64(len(testFile))
+		}
+
+		if err = client.send(&cor.PacketData{BlockNum: h.wireBlock(blk), Data: testFile[pos:end]}); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if blk%64 == 0 || blk == numBlocks {
+			receiveAck(t, client, int(h.wireBlock(blk)))
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, filename, testFile)
+}
+
+func TestPutNetascii(t *testing.T) {
+	server, stop := startTestServer(t, 11128, nil)
+	defer stop()
+
+	client, err := newFakeClient(11128)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	// the first block ends with the CR of a line ending whose LF begins the second block
+	onWire := append(bytes.Repeat([]byte("x"), cor.BlockSize-1), []byte("\r\nbare\r\x00carriage return\r\n")...)
+	want := append(bytes.Repeat([]byte("x"), cor.BlockSize-1), []byte("\nbare\rcarriage return\n")...)
+	filename := "netascii-put.txt"
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: filename, Mode: "netascii"}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	receiveAck(t, client, 0)
+	_ = client.send(&cor.PacketData{BlockNum: 1, Data: onWire[:cor.BlockSize]})
+	receiveAck(t, client, 1)
+	_ = client.send(&cor.PacketData{BlockNum: 2, Data: onWire[cor.BlockSize:]})
+	receiveAck(t, client, 2)
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, filename, want)
+}
+
+func TestPutAbortedIsDiscarded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "put-aborted")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+	store, err := stor.NewDirStore(dir)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, stop := startTestServer(t, 11129, func(s *Server) { s.store = store })
+	defer stop()
+
+	client, err := newFakeClient(11129)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "aborted.bin", Mode: "octet"}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the file is streamed to the store as it arrives, but only stored once the last block is received
+	receiveAck(t, client, 0)
+	_ = client.send(&cor.PacketData{BlockNum: 1, Data: makeTestData(cor.BlockSize)})
+	receiveAck(t, client, 1)
+	_ = client.send(&cor.PacketError{Code: cor.ErrUnknown, Msg: "giving up"})
+
+	time.Sleep(100 * time.Millisecond)
+	entries, _ := ioutil.ReadDir(dir)
+
+	if msg, ok := tcore.TAssertInt("files in the store", len(entries), 0); !ok {
+		t.Error(msg)
+	}
+}
+
+// receiveError waits for an error packet and fails the test if it does not carry code
+func receiveError(t *testing.T, client *fakeClient, code cor.ErrCode) {
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	pktErr, ok := packet.(*cor.PacketError)
+
+	if !ok {
+		t.Fatalf("want an error packet, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("pktErr.Code", int(pktErr.Code), int(code)); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestPutCreateOnly(t *testing.T) {
+	existing := cor.File{Name: "golden.img", Data: makeTestData(100)}
+	server, stop := startTestServer(t, 11130, func(s *Server) { s.WritePolicy = WriteCreateOnly }, existing)
+	defer stop()
+
+	client, err := newFakeClient(11130)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	// a file that exists is refused before anything is acknowledged
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: existing.Name, Mode: "octet"}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	receiveError(t, client, cor.ErrDupFile)
+
+	// a new file is accepted
+	newClient, err := newFakeClient(11130)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer newClient.close()
+	wrq.Filename = "new.log"
+	_ = newClient.send(&wrq)
+	receiveAck(t, newClient, 0)
+	_ = newClient.send(&cor.PacketData{BlockNum: 1, Data: []byte("log")})
+	receiveAck(t, newClient, 1)
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, "new.log", []byte("log"))
+	doPutTestAssertions(t, nil, server.store, existing.Name, existing.Data)
+}
+
+func TestPutWriteDenied(t *testing.T) {
+	server, stop := startTestServer(t, 11131, func(s *Server) { s.WritePolicy = WriteDenied })
+	defer stop()
+
+	client, err := newFakeClient(11131)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+
+	wrq := cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "denied.bin", Mode: "octet"}
+
+	if err = client.send(&wrq); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	receiveError(t, client, cor.ErrAccess)
+
+	if _, err = server.store.Get("denied.bin"); err == nil {
+		t.Error("the file should not have been stored")
+	}
+}
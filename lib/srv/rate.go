@@ -0,0 +1,138 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"sync"
+	"time"
+)
+
+// burstSeconds is how many seconds' worth of bytes a token bucket lets through at once after being idle
+const burstSeconds = 0.1
+
+// tokenBucket paces bytes to a rate. Each byte takes a token, and tokens are added at the rate up to a small burst.
+// Tokens may be taken ahead of the rate, leaving the bucket in debt, and the caller waits for the debt to be repaid.
+type tokenBucket struct {
+	mx     sync.Mutex
+	rate   float64   // the tokens, i.e. bytes, added per second
+	tokens float64   // the tokens available, negative when in debt
+	last   time.Time // when tokens were last added
+}
+
+func newTokenBucket(bytesPerSecond int64, now time.Time) *tokenBucket {
+	rate := float64(bytesPerSecond)
+	return &tokenBucket{rate: rate, tokens: rate * burstSeconds, last: now}
+}
+
+// reserve takes n tokens and returns how long the caller must wait before sending the n bytes
+func (b *tokenBucket) reserve(n int, now time.Time) time.Duration {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		b.last = now
+	}
+
+	if b.tokens > b.rate*burstSeconds {
+		b.tokens = b.rate * burstSeconds
+	}
+
+	b.tokens -= float64(n)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// pacer paces a transfer to every one of its buckets, e.g. the server's and the client's. A nil pacer does not pace.
+type pacer struct {
+	buckets []*tokenBucket
+}
+
+// delay takes n tokens from every bucket and returns how long to wait before sending the n bytes
+func (p *pacer) delay(n int) time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	now := time.Now()
+	var longest time.Duration
+
+	for _, b := range p.buckets {
+		if d := b.reserve(n, now); d > longest {
+			longest = d
+		}
+	}
+
+	return longest
+}
+
+// rateLimits holds the token buckets shared by transfers, one for the whole server and one for each client IP address
+// with transfers in progress
+type rateLimits struct {
+	mx       sync.Mutex
+	global   *tokenBucket           // created on first use
+	byClient map[string]*clientRate // the buckets of the clients with transfers in progress
+}
+
+// clientRate is the bucket of one client, shared by its transfers
+type clientRate struct {
+	bucket    *tokenBucket
+	transfers int // the transfers using the bucket, which is discarded when none are left
+}
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{byClient: make(map[string]*clientRate)}
+}
+
+// acquire returns the pacer of a transfer for client, limited to maxRate bytes per second for the whole server and
+// maxClientRate for the client, where 0 means no limit. The transfer must call release once it ends.
+func (r *rateLimits) acquire(client string, maxRate, maxClientRate int64) *pacer {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	p := &pacer{}
+	now := time.Now()
+
+	if maxRate > 0 {
+		if r.global == nil {
+			r.global = newTokenBucket(maxRate, now)
+		}
+
+		p.buckets = append(p.buckets, r.global)
+	}
+
+	if maxClientRate > 0 {
+		c, ok := r.byClient[client]
+
+		if !ok {
+			c = &clientRate{bucket: newTokenBucket(maxClientRate, now)}
+			r.byClient[client] = c
+		}
+
+		c.transfers++
+		p.buckets = append(p.buckets, c.bucket)
+	}
+
+	if len(p.buckets) == 0 {
+		return nil
+	}
+
+	return p
+}
+
+// release ends a transfer started with acquire, discarding the client's bucket once it has no transfers left
+func (r *rateLimits) release(client string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if c, ok := r.byClient[client]; ok {
+		c.transfers--
+
+		if c.transfers <= 0 {
+			delete(r.byClient, client)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Now()
+	b := newTokenBucket(1000, start)
+
+	// the burst of a tenth of a second goes at once, the rest waits for the rate
+	if d := b.reserve(100, start); d != 0 {
+		t.Errorf("the burst should not wait, got %s", d)
+	}
+
+	if d := b.reserve(100, start); d != 100*time.Millisecond {
+		t.Errorf("want 100ms, got %s", d)
+	}
+
+	// the debt is repaid as time passes
+	if d := b.reserve(100, start.Add(150*time.Millisecond)); d != 50*time.Millisecond {
+		t.Errorf("want 50ms, got %s", d)
+	}
+
+	// idle time refills no more than the burst
+	if d := b.reserve(100, start.Add(10*time.Second)); d != 0 {
+		t.Errorf("want 0, got %s", d)
+	}
+
+	if d := b.reserve(100, start.Add(10*time.Second)); d != 100*time.Millisecond {
+		t.Errorf("want 100ms, got %s", d)
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	r := newRateLimits()
+
+	if p := r.acquire("10.0.0.1", 0, 0); p != nil {
+		t.Error("want no pacer without limits")
+	}
+
+	a := r.acquire("10.0.0.1", 1000, 500)
+	b := r.acquire("10.0.0.1", 1000, 500)
+	c := r.acquire("10.0.0.2", 1000, 500)
+
+	// transfers share the server's bucket, and a client's transfers share its bucket
+	if a.buckets[0] != c.buckets[0] || a.buckets[1] != b.buckets[1] || a.buckets[1] == c.buckets[1] {
+		t.Error("the buckets are not shared as they should be")
+	}
+
+	r.release("10.0.0.1")
+	r.release("10.0.0.1")
+
+	if msg, ok := tcore.TAssertInt("len(r.byClient)", len(r.byClient), 1); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestGetMaxClientRate(t *testing.T) {
+	// the burst is 512 bytes, so the rest of the file takes at least 300ms at 5120 bytes per second
+	file := cor.File{Name: "paced.bin", Data: makeTestData(4*cor.BlockSize + 100)}
+	_, stop := startTestServer(t, 11139, func(s *Server) { s.MaxClientRate = 5120 }, file)
+	defer stop()
+
+	client, err := newFakeClient(11139)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	start := time.Now()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+
+	for blk := 1; blk <= 5; blk++ {
+		receiveData(t, client)
+		_ = client.send(&cor.PacketAck{BlockNum: uint16(blk)})
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("the transfer was not paced, it took %s", elapsed)
+	}
+}
+
+func TestPutMaxRate(t *testing.T) {
+	server, stop := startTestServer(t, 11140, func(s *Server) { s.MaxRate = 5120 })
+	defer stop()
+
+	client, err := newFakeClient(11140)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	data := makeTestData(4*cor.BlockSize + 100)
+	start := time.Now()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "paced.bin", Mode: "octet"})
+	receiveAck(t, client, 0)
+
+	for blk := 1; blk <= 5; blk++ {
+		end := blk * cor.BlockSize
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		_ = client.send(&cor.PacketData{BlockNum: uint16(blk), Data: data[(blk-1)*cor.BlockSize : end]})
+		receiveAck(t, client, blk)
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("the acknowledgements were not paced, it took %s", elapsed)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	doPutTestAssertions(t, nil, server.store, "paced.bin", data)
+}
@@ -0,0 +1,249 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// RemapAction is what a RemapRule does to a file name
+type RemapAction int
+
+const (
+	// RemapReplace replaces every match of the rule's Pattern with its Replacement
+	RemapReplace RemapAction = iota
+
+	// RemapLower folds the file name to lower case
+	RemapLower
+
+	// RemapUpper folds the file name to upper case
+	RemapUpper
+
+	// RemapSlashes replaces the backslashes in the file name with slashes
+	RemapSlashes
+)
+
+// String returns the name of the action in a remap file
+func (a RemapAction) String() string {
+	switch a {
+	case RemapReplace:
+		return "replace"
+	case RemapLower:
+		return "lower"
+	case RemapUpper:
+		return "upper"
+	case RemapSlashes:
+		return "backslash"
+	}
+
+	return fmt.Sprintf("RemapAction(%d)", int(a))
+}
+
+// RemapRule rewrites the file names that clients ask for before they reach the store. In the Replacement of a
+// RemapReplace rule, $1 or ${name} is replaced by a group of the match as in regexp.Regexp.Expand, ${ip} by the client's
+// IP address, ${hexip} by its IPv4 address in upper case hex as PXELINUX uses, e.g. C0A80001, and ${mac} by its MAC
+// address as aa-bb-cc-dd-ee-ff. The MAC address is looked up in the ARP table, and a rule that needs a MAC address it
+// cannot find is skipped.
+type RemapRule struct {
+	Action      RemapAction
+	Op          cor.OpType     // cor.OpRRQ or cor.OpWRQ, 0 for both
+	Pattern     *regexp.Regexp // for RemapReplace, the parts of the name to replace
+	Replacement string         // for RemapReplace, what they are replaced with
+}
+
+// ParseRemapRule parses a rule of the form "ACTION OP [PATTERN [REPLACEMENT]]", where ACTION is replace, lower, upper
+// or backslash, and OP is rrq, wrq or any. Only replace takes a PATTERN, which is a regular expression, and an
+// optional REPLACEMENT, without which the matches are removed. Neither may contain spaces, use \s in the pattern. e.g.
+//
+//	backslash any
+//	lower rrq
+//	replace any ^/?tftpboot/
+//	replace rrq ^pxelinux.cfg/default$ pxelinux.cfg/${mac}
+func ParseRemapRule(s string) (RemapRule, error) {
+	fields := strings.Fields(s)
+	r := RemapRule{}
+
+	if len(fields) < 2 {
+		return r, flog.Raisef("the remap rule '%s' should have the form ACTION OP [PATTERN [REPLACEMENT]]", s)
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "replace":
+		r.Action = RemapReplace
+	case "lower":
+		r.Action = RemapLower
+	case "upper":
+		r.Action = RemapUpper
+	case "backslash":
+		r.Action = RemapSlashes
+	default:
+		return r, flog.Raisef("the remap rule '%s' should begin with replace, lower, upper or backslash", s)
+	}
+
+	switch strings.ToLower(fields[1]) {
+	case "rrq":
+		r.Op = cor.OpRRQ
+	case "wrq":
+		r.Op = cor.OpWRQ
+	case "any":
+		r.Op = 0
+	default:
+		return r, flog.Raisef("the remap rule '%s' should apply to rrq, wrq or any", s)
+	}
+
+	if r.Action != RemapReplace {
+		if len(fields) > 2 {
+			return r, flog.Raisef("the remap rule '%s' takes no pattern", s)
+		}
+
+		return r, nil
+	}
+
+	if len(fields) < 3 || len(fields) > 4 {
+		return r, flog.Raisef("the remap rule '%s' should have the form replace OP PATTERN [REPLACEMENT]", s)
+	}
+
+	pattern, err := regexp.Compile(fields[2])
+
+	if err != nil {
+		return r, flog.Raisef("the remap rule '%s' has an invalid pattern: %s", s, err.Error())
+	}
+
+	r.Pattern = pattern
+
+	if len(fields) == 4 {
+		r.Replacement = fields[3]
+	}
+
+	return r, nil
+}
+
+// String returns the rule in the form read by ParseRemapRule
+func (r RemapRule) String() string {
+	op := "any"
+
+	if r.Op == cor.OpRRQ {
+		op = "rrq"
+	} else if r.Op == cor.OpWRQ {
+		op = "wrq"
+	}
+
+	if r.Action != RemapReplace || r.Pattern == nil {
+		return r.Action.String() + " " + op
+	}
+
+	return strings.TrimSpace(strings.Join([]string{r.Action.String(), op, r.Pattern.String(), r.Replacement}, " "))
+}
+
+// apply returns name as the rule rewrites it for a client at ip, and false if the rule cannot be applied
+func (r RemapRule) apply(name string, ip net.IP) (string, bool) {
+	switch r.Action {
+	case RemapLower:
+		return strings.ToLower(name), true
+	case RemapUpper:
+		return strings.ToUpper(name), true
+	case RemapSlashes:
+		return strings.Replace(name, "\\", "/", -1), true
+	case RemapReplace:
+		if r.Pattern == nil {
+			return name, false
+		}
+	default:
+		return name, false
+	}
+
+	replacement := r.Replacement
+
+	if strings.Contains(replacement, "${ip}") {
+		replacement = strings.Replace(replacement, "${ip}", ip.String(), -1)
+	}
+
+	if strings.Contains(replacement, "${hexip}") {
+		ip4 := ip.To4()
+
+		if ip4 == nil {
+			return name, false
+		}
+
+		replacement = strings.Replace(replacement, "${hexip}", fmt.Sprintf("%X", []byte(ip4)), -1)
+	}
+
+	if strings.Contains(replacement, "${mac}") {
+		mac, ok := lookupMAC(ip)
+
+		if !ok {
+			return name, false
+		}
+
+		replacement = strings.Replace(replacement, "${mac}", strings.Replace(mac.String(), ":", "-", -1), -1)
+	}
+
+	return r.Pattern.ReplaceAllString(name, replacement), true
+}
+
+// remap rewrites the file name of request with each of rules in turn, logging each rule that changes it
+func remap(rules []RemapRule, request *cor.PacketRequest, ip net.IP) {
+	for _, rule := range rules {
+		if rule.Op != 0 && rule.Op != request.OpCode {
+			continue
+		}
+
+		name, ok := rule.apply(request.Filename, ip)
+
+		if !ok {
+			flog.Infof("the remap rule '%s' was skipped for '%s' from %s, the client's address could not be "+
+				"substituted", rule.String(), request.Filename, ip.String())
+			continue
+		}
+
+		if name != request.Filename {
+			flog.Infof("'%s' from %s remapped to '%s' by rule '%s'", request.Filename, ip.String(), name,
+				rule.String())
+			request.Filename = name
+		}
+	}
+}
+
+// lookupMAC finds the MAC address of ip. It is a variable so that tests can replace it.
+var lookupMAC = arpLookup
+
+// arpLookup finds the MAC address of ip in the kernel's ARP table. It only finds addresses on Linux, where the table is
+// at /proc/net/arp.
+func arpLookup(ip net.IP) (net.HardwareAddr, bool) {
+	f, err := os.Open("/proc/net/arp")
+
+	if err != nil {
+		return nil, false
+	}
+
+	defer func() { _ = f.Close() }()
+	scanner := bufio.NewScanner(f)
+
+	// IP address  HW type  Flags  HW address  Mask  Device
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 4 || !ip.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+
+		// incomplete entries have no flags and an address of zeros
+		mac, err := net.ParseMAC(fields[3])
+
+		if err != nil || fields[2] == "0x0" {
+			return nil, false
+		}
+
+		return mac, true
+	}
+
+	return nil, false
+}
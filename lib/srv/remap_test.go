@@ -0,0 +1,96 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"net"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestParseRemapRule(t *testing.T) {
+	valid := map[string]string{
+		"backslash any":                     "backslash any",
+		"LOWER rrq":                         "lower rrq",
+		"upper wrq":                         "upper wrq",
+		"replace any ^/?tftpboot/":          "replace any ^/?tftpboot/",
+		"replace rrq ^(.*)\\.CFG$ ${1}.cfg": "replace rrq ^(.*)\\.CFG$ ${1}.cfg",
+	}
+
+	for s, want := range valid {
+		rule, err := ParseRemapRule(s)
+
+		if err != nil {
+			t.Errorf("ParseRemapRule(%q): %s", s, err.Error())
+		} else if msg, ok := tcore.TAssertString(s, rule.String(), want); !ok {
+			t.Error(msg)
+		}
+	}
+
+	invalid := []string{"", "lower", "fold any", "lower get", "lower any x", "replace any", "replace any ( x",
+		"replace any a b c"}
+
+	for _, s := range invalid {
+		if _, err := ParseRemapRule(s); err == nil {
+			t.Errorf("ParseRemapRule(%q) should have failed", s)
+		}
+	}
+}
+
+func TestRemap(t *testing.T) {
+	defer func(lookup func(net.IP) (net.HardwareAddr, bool)) { lookupMAC = lookup }(lookupMAC)
+	lookupMAC = func(ip net.IP) (net.HardwareAddr, bool) {
+		if !ip.Equal(net.ParseIP("192.168.0.1")) {
+			return nil, false
+		}
+
+		mac, err := net.ParseMAC("00:1A:2b:3c:4d:5e")
+		return mac, err == nil
+	}
+
+	var rules []RemapRule
+
+	for _, s := range []string{
+		"backslash any",
+		"lower rrq",
+		"replace any ^/?tftpboot/",
+		"replace rrq ^pxelinux.cfg/default$ pxelinux.cfg/${mac}",
+		"replace rrq ^pxelinux.cfg/hex$ pxelinux.cfg/${hexip}",
+		"replace wrq ^logs/ logs/${ip}/",
+	} {
+		rule, err := ParseRemapRule(s)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		rules = append(rules, rule)
+	}
+
+	tests := []struct {
+		ip   string
+		op   cor.OpType
+		name string
+		want string
+	}{
+		{"192.168.0.1", cor.OpRRQ, "\\TFTPBOOT\\Boot.IMG", "boot.img"},
+		{"192.168.0.1", cor.OpRRQ, "/tftpboot/pxelinux.cfg/default", "pxelinux.cfg/00-1a-2b-3c-4d-5e"},
+		{"192.168.0.1", cor.OpRRQ, "pxelinux.cfg/hex", "pxelinux.cfg/C0A80001"},
+		{"192.168.0.2", cor.OpWRQ, "Logs\\Boot.log", "Logs/Boot.log"},
+		{"192.168.0.2", cor.OpWRQ, "logs/boot.log", "logs/192.168.0.2/boot.log"},
+
+		// the MAC address of this client is unknown, so the rule is skipped
+		{"192.168.0.2", cor.OpRRQ, "pxelinux.cfg/default", "pxelinux.cfg/default"},
+	}
+
+	for _, test := range tests {
+		request := cor.PacketRequest{OpCode: test.op, Filename: test.name, Mode: "octet"}
+		remap(rules, &request, net.ParseIP(test.ip))
+
+		if msg, ok := tcore.TAssertString(test.name, request.Filename, test.want); !ok {
+			t.Error(msg)
+		}
+	}
+}
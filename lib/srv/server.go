@@ -3,6 +3,7 @@
 package srv
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
@@ -20,8 +21,16 @@ import (
 // TFTP (4 bytes), UDP (8 bytes) and IP (20 bytes). (source: google).
 const TftpMaxPacketSize = 1468
 
-const retries = 3
-const timeout = 3 * time.Second
+// DefaultTimeout is how long the server waits for a client before retransmitting, unless the client negotiates the
+// timeout option
+const DefaultTimeout = 3 * time.Second
+
+// DefaultRetries is how many times the server retransmits before abandoning a transfer
+const DefaultRetries = 3
+
+// DefaultMaxWindowSize is the largest window the server agrees to by default when a client negotiates windowsize
+const DefaultMaxWindowSize = 64
+
 const logChanDepth = 3
 
 // Server listens and responds to UDP TFTP Requests
@@ -32,68 +41,183 @@ type Server struct {
 	// logged to a file then leave it blank and connection logs will be written to stdout instead.
 	LogFilePath string
 
-	Port    int           // The listening port, defaults to 69 per TFTP standard
-	Verbose bool          // Sets the stdout logging to 'trace'. Does not affect the connection log
-	store   stor.Store    // stores and retrieves files by name
-	lch     chan LogEntry // log entries will be sent to this channel for the connection log
-	conn    *net.UDPConn  // is nil until Serve is called
-	stopMX  *sync.RWMutex // protects the stop boolean
-	stop    bool          // tells the Serve function when it should bail out
+	// MaxBlockSize is the largest block size the server will agree to when a client negotiates the blksize option.
+	// It defaults to TftpMaxPacketSize so that DATA packets are not fragmented on an Ethernet link. Raise it, up to
+	// cor.MaxBlockSize, for networks with jumbo frames.
+	MaxBlockSize int
+
+	// MaxFileSize is the largest file, in bytes, that a client may upload. Uploads that announce a larger tsize are
+	// refused before any data is transferred, and uploads that grow larger are aborted. Zero means no limit.
+	MaxFileSize int64
+
+	// Timeout is how long the server waits for a client before retransmitting. A client may override it for its own
+	// transfer with the timeout option. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// Retries is how many times the server retransmits before abandoning a transfer. Defaults to DefaultRetries.
+	Retries int
+
+	// MaxWindowSize is the largest number of blocks the server will agree to send, or receive, before an
+	// acknowledgement when a client negotiates the windowsize option. Defaults to DefaultMaxWindowSize.
+	MaxWindowSize int
+
+	// Rollover is the block number that follows 65535 in transfers of more than 65535 blocks, either 0 or 1. Clients
+	// may choose for themselves with the rollover option. Defaults to 0, and Serve returns an error for any other value.
+	Rollover int
+
+	// WritePolicy decides whether clients may upload files, and whether an upload may replace a file that exists.
+	// Uploads that the policy forbids are refused before any data is acknowledged. Defaults to WriteOverwrite.
+	WritePolicy WritePolicy
+
+	// Access decides which requests the server serves. With AccessReadOnly every WRQ is refused, and with
+	// AccessWriteOnly every RRQ is refused, with ErrAccess and before any options are negotiated. Defaults to
+	// AccessReadWrite.
+	Access Access
+
+	// Remap rewrites the file names that clients ask for, by each rule in turn, before the request is checked against
+	// Access and Rules and before the file is looked up in the store. Each rule that changes a name is logged. The
+	// name is then given one spelling, with slashes for backslashes and without a leading slash or "." and ".."
+	// elements, which is the name that Rules match and that the store is asked for.
+	Remap []RemapRule
+
+	// Rules decide which clients may read and write which files. They are checked in order when a request arrives and
+	// the first rule that matches the request decides whether it is served. A request no rule matches is served, so
+	// end with a rule that denies everything to serve only what is allowed. Denied requests are refused with ErrAccess
+	// and written to the connection log along with the rule that denied them.
+	Rules []Rule
+
+	// MaxTransfers is the most transfers the server runs at once. Requests beyond it wait up to QueueTimeout for a
+	// transfer to end, and are then refused with an error. Zero means no limit.
+	MaxTransfers int
+
+	// MaxTransfersPerClient is the most transfers the server runs at once for any one client IP address. Requests
+	// beyond it wait up to QueueTimeout for a transfer to end, and are then refused with an error. Zero means no limit.
+	MaxTransfersPerClient int
+
+	// QueueTimeout is how long a request waits for a transfer to end when it would exceed MaxTransfers or
+	// MaxTransfersPerClient. Zero refuses such requests at once.
+	QueueTimeout time.Duration
+
+	// MaxRate is the most bytes per second the server transfers, over all transfers and in either direction. DATA
+	// packets are held back to stay within it, and so are the acknowledgements that let a client send more of an
+	// upload. Zero means no limit.
+	MaxRate int64
+
+	// MaxClientRate is the most bytes per second the server transfers to and from any one client IP address, over all
+	// of its transfers. Zero means no limit.
+	MaxClientRate int64
+
+	Port      int                // The listening port, defaults to 69 per TFTP standard
+	Verbose   bool               // Sets the stdout logging to 'trace'. Does not affect the connection log
+	store     stor.Store         // stores and retrieves files by name
+	lch       chan LogEntry      // log entries will be sent to this channel for the connection log
+	logDone   chan struct{}      // closed when logAsync has written every entry and returned
+	conn      *net.UDPConn       // is nil until Serve is called
+	stopMX    *sync.RWMutex      // protects the stop boolean, conn, and the start of transfers
+	stop      bool               // tells the Serve function when it should bail out
+	transfers *sync.WaitGroup    // the transfers in progress, which Shutdown waits for
+	limits    *limiter           // counts the transfers in progress to keep them within limits
+	rates     *rateLimits        // the token buckets that pace transfers to MaxRate and MaxClientRate
+	ctx       context.Context    // the parent of every transfer, canceled to abandon the transfers in progress
+	cancel    context.CancelFunc // cancels ctx
 }
 
 // NewServer creates a new TFTP server. The Store is injected.
-// After NewServer, you should set Port and Verbose if you do not want the defaults.
+// After NewServer, you should set Port, Verbose and the transfer limits if you do not want the defaults.
 func NewServer(store stor.Store) Server {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := Server{
-		Port:    69,
-		Verbose: false,
-		store:   store,
-		lch:     make(chan LogEntry, logChanDepth),
-		conn:    nil,
-		stopMX:  new(sync.RWMutex),
-		stop:    false,
+		MaxBlockSize:  TftpMaxPacketSize,
+		Timeout:       DefaultTimeout,
+		Retries:       DefaultRetries,
+		MaxWindowSize: DefaultMaxWindowSize,
+		Port:          69,
+		Verbose:       false,
+		store:         store,
+		lch:           make(chan LogEntry, logChanDepth),
+		logDone:       nil,
+		conn:          nil,
+		stopMX:        new(sync.RWMutex),
+		stop:          false,
+		transfers:     new(sync.WaitGroup),
+		limits:        newLimiter(),
+		rates:         newRateLimits(),
+		ctx:           ctx,
+		cancel:        cancel,
 	}
 	return s
 }
 
-func sendError(conn *net.UDPConn, theError error) error {
-	pktErr := cor.PacketError{}
-	pktErr.Code = cor.OpError
-	pktErr.Msg = theError.Error()
-	_, err := conn.Write(pktErr.Serialize())
-	return err
+// Serve listens for incoming UDP TFTP connections and responds to them. Serve blocks until server.Stop or
+// server.Shutdown is called by another goroutine. It is recommended to run Serve in its own goroutine due to its
+// blocking nature.
+func (s *Server) Serve() error {
+	return s.ServeContext(context.Background())
 }
 
-// Serve listens for incoming UDP TFTP connections and responds to them. Serve blocks until server.Stop is called
-// by another goroutine. It is recommended to run Serve in its own goroutine due to its blocking nature.
-func (s *Server) Serve() error {
+// ServeContext is Serve, but it also stops the server, as Stop does, when ctx is done. In that case it returns once the
+// server has stopped.
+func (s *Server) ServeContext(ctx context.Context) error {
 	defer flog.Trace("stopped")
-	go s.logAsync()
-	mainListener, err := makeListener(uint16(s.Port))
+
+	if s.Rollover != 0 && s.Rollover != 1 {
+		return flog.Raisef("the rollover must be 0 or 1, not %d", s.Rollover)
+	}
+
+	listener, err := makeListener(uint16(s.Port))
 
 	if err != nil {
 		return err
 	}
 
-	s.conn = mainListener
+	s.stopMX.Lock()
 
-	for {
-		s.stopMX.RLock()
-		if s.stop {
-			s.stopMX.RUnlock()
-			return nil
-		}
-		s.stopMX.RUnlock()
-		handshake, err := waitForHandshake(s.conn)
+	if s.stop {
+		s.stopMX.Unlock()
+		_ = listener.Close()
+		return nil
+	}
 
-		s.stopMX.RLock()
-		if s.stop {
-			s.stopMX.RUnlock()
-			return nil
+	s.conn = listener
+	s.logDone = make(chan struct{})
+	go s.logAsync()
+	s.stopMX.Unlock()
+
+	// stops the server when ctx is done, unless the server stops first
+	served := make(chan struct{})
+	stopped := make(chan error, 1)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stopped <- s.Stop()
+		case <-served:
 		}
-		s.stopMX.RUnlock()
 
-		if err != nil {
+		close(stopped)
+	}()
+
+	err = s.listen(listener)
+	close(served)
+
+	if stopErr, ok := <-stopped; ok && err == nil {
+		err = stopErr
+	}
+
+	return err
+}
+
+// listen answers requests on listener until the server is stopped
+func (s *Server) listen(listener *net.UDPConn) error {
+	for {
+		handshake, err := waitForHandshake(listener)
+
+		if s.isStopped() {
+			return nil
+		} else if bad, ok := err.(badRequest); ok {
+			flog.Infof("%s", bad.Error())
+			continue
+		} else if err != nil {
 			return err
 		}
 
@@ -101,19 +225,121 @@ func (s *Server) Serve() error {
 			Start: time.Now(),
 		}
 
+		remap(s.Remap, &handshake.tftpInfo, handshake.client.IP)
+		handshake.tftpInfo.Filename = canonicalName(handshake.tftpInfo.Filename)
+
+		if e := checkAccess(s.Access, handshake.tftpInfo); e != nil {
+			s.refuse(handshake, l, e, "")
+			continue
+		}
+
+		if e, rule := checkRules(s.Rules, handshake.tftpInfo, handshake.client.IP); e != nil {
+			s.refuse(handshake, l, e, rule.String())
+			continue
+		}
+
+		if e := s.negotiate(&handshake); e != nil {
+			s.refuse(handshake, l, e, "")
+			continue
+		}
+
 		if handshake.tftpInfo.IsWRQ() {
-			go doAsyncTransfer(handshake, s.store, l, s.lch, put)
+			s.startTransfer(handshake, l, put)
 		} else if handshake.tftpInfo.IsRRQ() {
-			go doAsyncTransfer(handshake, s.store, l, s.lch, get)
+			s.startTransfer(handshake, l, get)
 		} else {
 			go s.sendBadOp(handshake)
 		}
+	}
+}
 
-		if err != nil {
-			return err
+// startTransfer runs a transfer on its own goroutine, unless the server has begun to stop. The transfer is counted
+// while holding the lock, so that Shutdown cannot begin waiting before it is counted. It first waits for the transfer
+// to fit within the server's limits, and is refused if it does not fit in time. A request from a client address that
+// already has a request waiting or running is a resend of that request, and is dropped.
+func (s *Server) startTransfer(h handshake, l LogEntry, f transferFunction) {
+	s.stopMX.RLock()
+	defer s.stopMX.RUnlock()
+
+	if s.stop {
+		return
+	}
+
+	addr := h.client.String()
+
+	if !s.limits.claim(addr) {
+		flog.Trace(fmt.Sprintf("dropped a repeated request for '%s' from %s", h.tftpInfo.Filename, addr))
+		return
+	}
+
+	h.ctx = s.ctx
+	s.transfers.Add(1)
+
+	go func() {
+		defer s.transfers.Done()
+		defer s.limits.unclaim(addr)
+		client := h.client.IP.String()
+
+		// the slot is taken before the transfer opens its connection, so that queued requests hold no sockets
+		if e := s.limits.acquire(s.ctx, client, s.MaxTransfers, s.MaxTransfersPerClient, s.QueueTimeout); e != nil {
+			s.sendRefusal(h, e)
+			s.logRefusal(h, l, e, "")
+			return
 		}
+
+		defer s.limits.release(client)
+		h.pacer = s.rates.acquire(client, s.MaxRate, s.MaxClientRate)
+		defer s.rates.release(client)
+		doAsyncTransfer(h, s.store, l, s.lch, f)
+	}()
+}
+
+// refuse answers a request the server will not serve with the error e on its own goroutine, and logs the refusal to
+// the connection log along with the text of the rule that refused it, if any. The refusal is counted with the
+// transfers, so that the connection log is not closed before it is logged.
+func (s *Server) refuse(h handshake, l LogEntry, e *cor.Err, rule string) {
+	s.stopMX.RLock()
+	defer s.stopMX.RUnlock()
+
+	if s.stop {
+		return
 	}
-	// unreachable
+
+	s.transfers.Add(1)
+
+	go func() {
+		defer s.transfers.Done()
+		s.sendRefusal(h, e)
+		s.logRefusal(h, l, e, rule)
+	}()
+}
+
+// logRefusal sends the connection log an entry for a request that was refused with the error e by rule, if any
+func (s *Server) logRefusal(h handshake, l LogEntry, e *cor.Err, rule string) {
+	l.Duration = time.Since(l.Start)
+	l.Client = h.client
+	l.File = h.tftpInfo.Filename
+	l.Op = h.tftpInfo.Op()
+	l.Error = e
+	l.Rule = rule
+	s.lch <- l
+}
+
+// ActiveTransfers returns the number of transfers in progress, not counting requests waiting for one to end
+func (s *Server) ActiveTransfers() int {
+	return s.limits.active()
+}
+
+// ActiveTransfersByClient returns the number of transfers in progress for each client, by IP address
+func (s *Server) ActiveTransfersByClient() map[string]int {
+	return s.limits.activeByClient()
+}
+
+// isStopped returns true once Stop or Shutdown has been called
+func (s *Server) isStopped() bool {
+	s.stopMX.RLock()
+	defer s.stopMX.RUnlock()
+	return s.stop
 }
 
 func (s *Server) sendBadOp(h handshake) {
@@ -132,31 +358,105 @@ func (s *Server) sendBadOp(h handshake) {
 	}
 }
 
-// Stop will stop the server and cause server.Serve() to exit
+// sendRefusal answers a request the server will not serve with the error e
+func (s *Server) sendRefusal(h handshake, e *cor.Err) {
+	conn, err := net.DialUDP("udp", &h.server, &h.client)
+
+	if err != nil {
+		flog.Error(err.Error())
+		return
+	}
+
+	defer func() { _ = conn.Close() }()
+	err = e.Send(conn)
+
+	if err != nil {
+		flog.Error(err.Error())
+		return
+	}
+}
+
+// Stop stops the server at once. Transfers in progress are abandoned, each client being sent an error packet, and
+// then the connection log is flushed and the store is terminated. Serve returns once Stop has been called.
 func (s *Server) Stop() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.shutdown(ctx)
+	return err
+}
+
+// Shutdown stops the server gracefully. The server stops accepting requests at once, then waits for the transfers in
+// progress to finish. If ctx is done first, the remaining transfers are abandoned, each client being sent an error
+// packet, and ctx.Err() is returned. Once every transfer has ended, the connection log is flushed and the store is
+// terminated.
+func (s *Server) Shutdown(ctx context.Context) error {
+	abandoned, err := s.shutdown(ctx)
+
+	if abandoned {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// shutdown stops the server, returning true if transfers had to be abandoned when ctx was done, and any error
+// closing the listener
+func (s *Server) shutdown(ctx context.Context) (bool, error) {
 	defer flog.Trace("stopped")
-	var err error
 	s.stopMX.Lock()
-	defer s.stopMX.Unlock()
+
+	if s.stop {
+		s.stopMX.Unlock()
+		return false, nil
+	}
+
 	s.stop = true
+	conn := s.conn
+	s.conn = nil
+	s.stopMX.Unlock()
+	var err error
+
+	if conn != nil {
+		err = conn.Close()
+	}
+
+	finished := make(chan struct{})
 
-	if s.conn != nil {
-		err = s.conn.Close()
-		s.conn = nil
+	go func() {
+		s.transfers.Wait()
+		close(finished)
+	}()
+
+	abandoned := false
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		abandoned = true
+		s.cancel()
+		<-finished
 	}
 
+	s.cancel()
+
+	// no transfer is left to send a log entry
 	close(s.lch)
 
+	if s.logDone != nil {
+		<-s.logDone
+	}
+
 	if s.store != nil {
 		s.store.Terminate()
 	}
 
-	return err
+	return abandoned, err
 }
 
 // logAsync runs on its own goroutine, receiving and writing connection logs
 func (s *Server) logAsync() {
 	defer flog.Trace("exit")
+	defer close(s.logDone)
 
 	// create the file, will be appended with each log entry
 	if len(s.LogFilePath) > 0 {
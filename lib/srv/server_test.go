@@ -3,11 +3,17 @@
 package srv
 
 import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/webern/flog"
 	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
 	"github.com/webern/tftp/lib/stor"
 )
 
@@ -144,3 +150,488 @@ func TestServer(t *testing.T) {
 //		}
 //	}
 //}
+
+func TestServerReadOnly(t *testing.T) {
+	file := cor.File{Name: "boot.img", Data: makeTestData(100)}
+	server, stop := startTestServer(t, 11132, func(s *Server) { s.Access = AccessReadOnly }, file)
+	defer stop()
+
+	writer, err := newFakeClient(11132)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer writer.close()
+	_ = writer.send(&cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "upload.bin", Mode: "octet"})
+	receiveError(t, writer, cor.ErrAccess)
+
+	if _, err = server.store.Get("upload.bin"); err == nil {
+		t.Error("the file should not have been stored")
+	}
+
+	reader, err := newFakeClient(11132)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer reader.close()
+	_ = reader.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	packet, err := reader.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := packet.(*cor.PacketData); !ok {
+		t.Errorf("want a data packet, got op %s", packet.Op().String())
+	}
+
+	_ = reader.send(&cor.PacketAck{BlockNum: 1})
+}
+
+func TestServerWriteOnly(t *testing.T) {
+	file := cor.File{Name: "device.log", Data: makeTestData(100)}
+	_, stop := startTestServer(t, 11133, func(s *Server) { s.Access = AccessWriteOnly }, file)
+	defer stop()
+
+	reader, err := newFakeClient(11133)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer reader.close()
+	_ = reader.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveError(t, reader, cor.ErrAccess)
+
+	writer, err := newFakeClient(11133)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer writer.close()
+	_ = writer.send(&cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "upload.log", Mode: "octet"})
+	receiveAck(t, writer, 0)
+	_ = writer.send(&cor.PacketData{BlockNum: 1, Data: []byte("log")})
+	receiveAck(t, writer, 1)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	file := cor.File{Name: "drain.bin", Data: makeTestData(3*cor.BlockSize + 10)}
+	server, stop := startTestServer(t, 11134, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11134)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveData(t, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(ctx) }()
+
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Shutdown returned while a transfer was in progress: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// new requests are no longer answered
+	late, err := newFakeClient(11134)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer late.close()
+	_ = late.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+
+	if _, err = late.receiveWithin(100 * time.Millisecond); err == nil {
+		t.Error("a request was answered after Shutdown")
+	}
+
+	// the transfer in progress runs to the end
+	for blk := 1; blk <= 4; blk++ {
+		_ = client.send(&cor.PacketAck{BlockNum: uint16(blk)})
+
+		if blk < 4 {
+			receiveData(t, client)
+		}
+	}
+
+	select {
+	case err = <-shutdown:
+		if msg, ok := tcore.TErr("server.Shutdown(ctx)", err); !ok {
+			t.Error(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown did not return after the transfer finished")
+	}
+
+	if _, err = server.store.Get(file.Name); err == nil {
+		t.Error("the store should have been terminated")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	file := cor.File{Name: "abandoned.bin", Data: makeTestData(3 * cor.BlockSize)}
+	server, stop := startTestServer(t, 11135, nil, file)
+	defer stop()
+
+	client, err := newFakeClient(11135)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveData(t, client)
+
+	// the client never acknowledges, so the transfer is abandoned at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = server.Shutdown(ctx)
+
+	if err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+
+	receiveError(t, client, cor.ErrUnknown)
+}
+
+func TestServeContext(t *testing.T) {
+	store := stor.NewMemStore()
+	server := NewServer(store)
+	server.Port = 11136
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.ServeContext(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-served:
+		if msg, ok := tcore.TErr("server.ServeContext(ctx)", err); !ok {
+			t.Error(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ServeContext did not return when its context was canceled")
+	}
+
+	// the server has stopped completely by the time ServeContext returns
+	if err := store.Put(cor.File{Name: "late.bin"}); err == nil {
+		t.Error("the store should have been terminated")
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Errorf("a second Stop should do nothing, got %s", err.Error())
+	}
+}
+
+func TestServerRules(t *testing.T) {
+	file := cor.File{Name: "boot.img", Data: makeTestData(100)}
+	deny, err := ParseRule("deny wrq 127.0.0.0/8")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "tftp-rules")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer func() { _ = os.RemoveAll(dir) }()
+	logFile := filepath.Join(dir, "connection.log")
+	configure := func(s *Server) {
+		s.Rules = []Rule{deny}
+		s.LogFilePath = logFile
+	}
+
+	server, stop := startTestServer(t, 11141, configure, file)
+	defer stop()
+
+	writer, err := newFakeClient(11141)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer writer.close()
+	_ = writer.send(&cor.PacketRequest{OpCode: cor.OpWRQ, Filename: "upload.bin", Mode: "octet"})
+	receiveError(t, writer, cor.ErrAccess)
+
+	if _, err = server.store.Get("upload.bin"); err == nil {
+		t.Error("the file should not have been stored")
+	}
+
+	reader, err := newFakeClient(11141)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer reader.close()
+	_ = reader.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	packet, err := reader.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := packet.(*cor.PacketData); !ok {
+		t.Errorf("want a data packet, got op %s", packet.Op().String())
+	}
+
+	_ = reader.send(&cor.PacketAck{BlockNum: 1})
+
+	// the refusal reaches the connection log with the rule that denied it
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	log, err := ioutil.ReadFile(logFile)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !strings.Contains(string(log), "RULE: 'deny wrq 127.0.0.0/8'") {
+		t.Errorf("the denied request is not in the connection log:\n%s", string(log))
+	}
+}
+
+func TestServerRemap(t *testing.T) {
+	file := cor.File{Name: "pxe/boot.img", Data: makeTestData(100)}
+	var rules []RemapRule
+
+	for _, s := range []string{"backslash any", "lower any"} {
+		rule, err := ParseRemapRule(s)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		rules = append(rules, rule)
+	}
+
+	_, stop := startTestServer(t, 11142, func(s *Server) { s.Remap = rules }, file)
+	defer stop()
+
+	client, err := newFakeClient(11142)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: "PXE\\Boot.IMG", Mode: "octet"})
+	packet, err := client.receive()
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	data, ok := packet.(*cor.PacketData)
+
+	if !ok {
+		t.Fatalf("want a data packet, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("len(data.Data)", len(data.Data), len(file.Data)); !ok {
+		t.Error(msg)
+	}
+
+	_ = client.send(&cor.PacketAck{BlockNum: 1})
+}
+
+func TestServerMaxTransfers(t *testing.T) {
+	file := cor.File{Name: "busy.bin", Data: makeTestData(3 * cor.BlockSize)}
+	server, stop := startTestServer(t, 11137, func(s *Server) { s.MaxTransfers = 1 }, file)
+	defer stop()
+
+	first, err := newFakeClient(11137)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer first.close()
+	_ = first.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveData(t, first)
+
+	if msg, ok := tcore.TAssertInt("server.ActiveTransfers()", server.ActiveTransfers(), 1); !ok {
+		t.Error(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("127.0.0.1", server.ActiveTransfersByClient()["127.0.0.1"], 1); !ok {
+		t.Error(msg)
+	}
+
+	// without a queue, a request beyond the limit is refused at once
+	second, err := newFakeClient(11137)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer second.close()
+	_ = second.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveError(t, second, cor.ErrUnknown)
+}
+
+func TestServerQueueTimeout(t *testing.T) {
+	file := cor.File{Name: "queued.bin", Data: makeTestData(100)}
+	configure := func(s *Server) {
+		s.MaxTransfersPerClient = 1
+		s.QueueTimeout = 2 * time.Second
+	}
+
+	server, stop := startTestServer(t, 11138, configure, file)
+	defer stop()
+
+	first, err := newFakeClient(11138)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer first.close()
+	_ = first.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveData(t, first)
+
+	// the second request waits for the first transfer to end
+	second, err := newFakeClient(11138)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer second.close()
+	_ = second.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+
+	if _, err = second.receiveWithin(100 * time.Millisecond); err == nil {
+		t.Fatal("the second request was served beyond the limit")
+	}
+
+	_ = first.send(&cor.PacketAck{BlockNum: 1})
+	receiveData(t, second)
+	_ = second.send(&cor.PacketAck{BlockNum: 1})
+
+	time.Sleep(50 * time.Millisecond)
+
+	if msg, ok := tcore.TAssertInt("server.ActiveTransfers()", server.ActiveTransfers(), 0); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestServerQueuedResend(t *testing.T) {
+	file := cor.File{Name: "resent.bin", Data: makeTestData(100)}
+	configure := func(s *Server) {
+		s.MaxTransfersPerClient = 1
+		s.QueueTimeout = 2 * time.Second
+	}
+
+	server, stop := startTestServer(t, 11144, configure, file)
+	defer stop()
+
+	first, err := newFakeClient(11144)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer first.close()
+	_ = first.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	receiveData(t, first)
+
+	// the second client resends its request while it waits in the queue
+	second, err := newFakeClient(11144)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer second.close()
+	_ = second.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	time.Sleep(50 * time.Millisecond)
+	_ = second.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	time.Sleep(50 * time.Millisecond)
+
+	_ = first.send(&cor.PacketAck{BlockNum: 1})
+	receiveData(t, second)
+	_ = second.send(&cor.PacketAck{BlockNum: 1})
+
+	// the resent request is not served a second time
+	if packet, err := second.receiveWithin(300 * time.Millisecond); err == nil {
+		t.Errorf("the resent request was served again, got op %s", packet.Op().String())
+	}
+
+	if msg, ok := tcore.TAssertInt("server.ActiveTransfers()", server.ActiveTransfers(), 0); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestServeInvalidRollover(t *testing.T) {
+	server := NewServer(stor.NewMemStore())
+	server.Port = 11145
+	server.Rollover = 2
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve() }()
+
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Error("a rollover of 2 should have been refused")
+		}
+	case <-time.After(time.Second):
+		_ = server.Stop()
+		t.Error("a rollover of 2 should have been refused, but the server started")
+	}
+}
+
+func TestServeAfterBadRequest(t *testing.T) {
+	file := cor.File{Name: "a", Data: makeTestData(100)}
+	_, stop := startTestServer(t, 11146, nil, file)
+	defer stop()
+
+	// an empty packet or one that is not a request is ignored, a request with an option cut short is served without it
+	for _, packet := range []string{"", "\x00\x09junk", "\x00\x01a\x00octet\x00blksize\x00"} {
+		client, err := newFakeClient(11146)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err = client.conn.WriteToUDP([]byte(packet), client.server); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		client.close()
+	}
+
+	client, err := newFakeClient(11146)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	defer client.close()
+	_ = client.send(&cor.PacketRequest{OpCode: cor.OpRRQ, Filename: file.Name, Mode: "octet"})
+	data := receiveData(t, client)
+
+	if msg, ok := tcore.TAssertInt("len(data.Data)", len(data.Data), len(file.Data)); !ok {
+		t.Error(msg)
+	}
+}
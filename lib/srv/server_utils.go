@@ -5,6 +5,8 @@ package srv
 import (
 	"fmt"
 	"net"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
@@ -56,14 +58,16 @@ func waitForHandshake(conn *net.UDPConn) (handshake, error) {
 		return handshake{}, flog.Wrap(err)
 	}
 
-	if ua == nil || numBytes <= 0 {
+	if ua == nil {
 		return handshake{}, flog.Raise("unable to receive the udp packet")
+	} else if numBytes <= 0 {
+		return handshake{}, badRequest{err: flog.Raise("the packet is empty"), client: ua}
 	}
 
-	tftpInfo, err := parsePacket(buf)
+	tftpInfo, err := parsePacket(buf[:numBytes])
 
 	if err != nil {
-		return handshake{}, err
+		return handshake{}, badRequest{err: err, client: ua}
 	}
 
 	serverAddress, err := net.ResolveUDPAddr("udp", ":0")
@@ -79,6 +83,17 @@ func waitForHandshake(conn *net.UDPConn) (handshake, error) {
 	return handshk, nil
 }
 
+// badRequest is the error of a packet sent to the listener that is not a request the server can parse. The packet is
+// ignored, it is not a reason to stop the server.
+type badRequest struct {
+	err    error
+	client *net.UDPAddr
+}
+
+func (b badRequest) Error() string {
+	return fmt.Sprintf("a bad request from %s was ignored: %s", b.client.String(), b.err.Error())
+}
+
 func parsePacket(buf []byte) (*cor.PacketRequest, error) {
 	pkt, err := cor.ParsePacket(buf)
 	if err != nil {
@@ -106,12 +121,36 @@ func memset(b []byte) {
 	}
 }
 
+// canonicalName returns the name a store would use for a requested file name, with slashes for backslashes, without
+// repeated slashes, "." or ".." elements that stay inside of the root, and without a leading slash, so that rules see
+// one spelling of each file. A name that climbs out of the root with ".." keeps its leading ".." for the store to
+// refuse.
+func canonicalName(name string) string {
+	cleaned := path.Clean(strings.Replace(name, "\\", "/", -1))
+
+	if cleaned == "." {
+		return ""
+	}
+
+	return strings.TrimLeft(cleaned, "/")
+}
+
+// transferStats summarizes a transfer for the connection log
+type transferStats struct {
+	numBytes int64 // the number of bytes transferred
+	retries  int   // the number of times the client failed to respond in time
+}
+
 // transferFunction is a type alias for get and put, which both share the logic in doAsyncTransfer
-type transferFunction = func(hndshk handshake, store stor.Store) (conn *net.UDPConn, numBytes int, err error)
+type transferFunction = func(hndshk handshake, store stor.Store) (conn *net.UDPConn, stats transferStats, err error)
 
 // doAsyncTransfer wraps both the get and put functions with error handling and logging stuff
 func doAsyncTransfer(hndshk handshake, store stor.Store, l LogEntry, lch chan<- LogEntry, f transferFunction) {
-	conn, n, err := f(hndshk, store)
+	conn, stats, err := f(hndshk, store)
+
+	if conn != nil {
+		defer func() { _ = conn.Close() }()
+	}
 
 	if err != nil {
 		switch e := err.(type) {
@@ -134,9 +173,11 @@ func doAsyncTransfer(hndshk handshake, store stor.Store, l LogEntry, lch chan<-
 			}
 		}
 	} else {
-		l.Bytes = n
+		l.Bytes = stats.numBytes
 	}
 
+	l.Retries = stats.retries
+
 	l.Duration = time.Since(l.Start)
 	l.Client = hndshk.client
 	l.File = hndshk.tftpInfo.Filename
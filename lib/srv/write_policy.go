@@ -0,0 +1,62 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package srv
+
+import (
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+	"github.com/webern/tftp/lib/stor"
+)
+
+// WritePolicy decides whether clients may upload files, and whether an upload may replace a file that exists
+type WritePolicy int
+
+const (
+	// WriteOverwrite accepts uploads, replacing any file by the same name. This is the default.
+	WriteOverwrite WritePolicy = iota
+
+	// WriteCreateOnly accepts uploads of new files only. A WRQ for a file that exists is refused with ErrDupFile.
+	WriteCreateOnly
+
+	// WriteDenied refuses every WRQ with ErrAccess
+	WriteDenied
+)
+
+// String returns the name of the policy
+func (p WritePolicy) String() string {
+	switch p {
+	case WriteOverwrite:
+		return "overwrite"
+	case WriteCreateOnly:
+		return "create"
+	case WriteDenied:
+		return "none"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseWritePolicy returns the policy named by s, which is one of the names returned by String
+func ParseWritePolicy(s string) (WritePolicy, error) {
+	for _, p := range []WritePolicy{WriteOverwrite, WriteCreateOnly, WriteDenied} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+
+	return WriteOverwrite, flog.Raisef("unknown write policy '%s', want overwrite, create or none", s)
+}
+
+// checkWrite returns the error a WRQ for name is refused with under policy, or nil if the upload may go ahead
+func checkWrite(policy WritePolicy, store stor.Store, name string) *cor.Err {
+	switch policy {
+	case WriteDenied:
+		return cor.NewErrf(cor.ErrAccess, "uploads are not accepted")
+	case WriteCreateOnly:
+		if stor.Exists(store, name) {
+			return cor.NewErrf(cor.ErrDupFile, "the file '%s' already exists", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+var _ Store = (*archiveStore)(nil)
+var _ Streamer = (*archiveStore)(nil)
+var _ Checker = (*archiveStore)(nil)
+var _ WriteChecker = (*archiveStore)(nil)
+
+// archiveStore implements the Store and Streamer interfaces for reading the files in a tar, tar.gz or zip archive
+type archiveStore struct {
+	file       *os.File                // the archive, read with ReadAt so that entries can be read concurrently
+	entries    map[string]archiveEntry // the regular files in the archive, by normalized name
+	mx         sync.RWMutex            // protects terminated
+	terminated bool                    // when true, all functions return an error
+}
+
+// archiveEntry locates a file in an archive
+type archiveEntry struct {
+	size int64
+	open func() (io.ReadCloser, error) // opens the entry for reading from its start
+	at   *io.SectionReader             // the entry itself, if it is stored uncompressed and can be read at random
+}
+
+// NewArchiveStore creates a read-only Store that serves the files in the archive at path, which may be a tar file, a
+// gzipped tar file or a zip file. The format is detected from the content of the file. The archive is indexed once
+// when it is opened and each file is read from the archive when it is requested. File names are the paths within the
+// archive, without any leading "./" or "/". Put is refused with an ErrAccess error.
+func NewArchiveStore(path string) (Store, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		_ = f.Close()
+		return nil, flog.Wrap(err)
+	}
+
+	a := &archiveStore{file: f}
+	magic := make([]byte, 4)
+	n, _ := f.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")) {
+		a.entries, err = indexZip(f, info.Size())
+	} else if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		a.entries, err = indexTarGz(f, info.Size())
+	} else {
+		a.entries, err = indexTar(f, info.Size())
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, flog.Raisef("the archive '%s' could not be read: %s", path, err.Error())
+	}
+
+	return a, nil
+}
+
+// Get returns a file from the archive
+func (a *archiveStore) Get(name string) (cor.File, error) {
+	r, _, err := a.Open(name)
+
+	if err != nil {
+		return cor.File{}, err
+	}
+
+	defer func() { _ = r.Close() }()
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return cor.File{}, flog.Wrap(err)
+	}
+
+	return cor.File{Name: name, Data: data}, nil
+}
+
+// Put is refused, the archive is read-only
+func (a *archiveStore) Put(f cor.File) error {
+	return cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written, the archive is read-only", f.Name)
+}
+
+// Open returns a reader for a file in the archive
+func (a *archiveStore) Open(name string) (FileReader, int64, error) {
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+
+	if a.terminated {
+		return nil, 0, flog.Raise("the archiveStore has been terminated")
+	}
+
+	entry, ok := a.entries[normalizeEntryName(name)]
+
+	if !ok {
+		return nil, 0, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+	}
+
+	if entry.at != nil {
+		return sectionReader{io.NewSectionReader(entry.at, 0, entry.size)}, entry.size, nil
+	}
+
+	return &entryReader{open: entry.open, size: entry.size}, entry.size, nil
+}
+
+// Exists returns true if the archive holds the named file
+func (a *archiveStore) Exists(name string) bool {
+	a.mx.RLock()
+	defer a.mx.RUnlock()
+	_, ok := a.entries[normalizeEntryName(name)]
+	return ok && !a.terminated
+}
+
+// Writable returns false, the archive is read-only
+func (a *archiveStore) Writable(name string) bool {
+	return false
+}
+
+// Create is refused, the archive is read-only
+func (a *archiveStore) Create(name string) (FileWriter, error) {
+	return nil, cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written, the archive is read-only", name)
+}
+
+// Terminate closes the archive
+func (a *archiveStore) Terminate() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	defer flog.Trace("terminated")
+
+	if !a.terminated {
+		_ = a.file.Close()
+	}
+
+	a.terminated = true
+}
+
+// normalizeEntryName converts the name of an archive entry, or of a requested file, to the name it is indexed by
+func normalizeEntryName(name string) string {
+	name = path.Clean("/" + strings.Replace(name, "\\", "/", -1))
+	return strings.TrimPrefix(name, "/")
+}
+
+// indexZip indexes the regular files in a zip archive
+func indexZip(r io.ReaderAt, size int64) (map[string]archiveEntry, error) {
+	zr, err := zip.NewReader(r, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]archiveEntry)
+
+	for _, zf := range zr.File {
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		entry := archiveEntry{size: int64(zf.UncompressedSize64), open: zf.Open}
+
+		// a stored entry can be read in place
+		if offset, err := zf.DataOffset(); err == nil && zf.Method == zip.Store {
+			entry.at = io.NewSectionReader(r, offset, entry.size)
+		}
+
+		entries[normalizeEntryName(zf.Name)] = entry
+	}
+
+	return entries, nil
+}
+
+// indexTar indexes the regular files in an uncompressed tar archive, each of which can be read in place
+func indexTar(r io.ReaderAt, size int64) (map[string]archiveEntry, error) {
+	return scanTar(io.NewSectionReader(r, 0, size), func(offset, entrySize int64) archiveEntry {
+		return archiveEntry{size: entrySize, at: io.NewSectionReader(r, offset, entrySize)}
+	})
+}
+
+// indexTarGz indexes the regular files in a gzipped tar archive. A gzip stream can only be read from its start, so
+// each file is read by decompressing the archive up to the file.
+func indexTarGz(r io.ReaderAt, size int64) (map[string]archiveEntry, error) {
+	gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return scanTar(gz, func(offset, entrySize int64) archiveEntry {
+		open := func() (io.ReadCloser, error) {
+			gz, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err = io.CopyN(ioutil.Discard, gz, offset); err != nil {
+				_ = gz.Close()
+				return nil, err
+			}
+
+			return limitedReadCloser{io.LimitReader(gz, entrySize), gz}, nil
+		}
+
+		return archiveEntry{size: entrySize, open: open}
+	})
+}
+
+// scanTar reads the tar stream r, calling makeEntry with the offset in the stream and the size of each regular file
+func scanTar(r io.Reader, makeEntry func(offset, size int64) archiveEntry) (map[string]archiveEntry, error) {
+	// tar.Reader consumes exactly the headers of an entry before returning it, so the number of bytes read so far is
+	// the offset of the entry's data
+	counter := &countingReader{r: r}
+	tr := tar.NewReader(counter)
+	entries := make(map[string]archiveEntry)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hdr.FileInfo().Mode().IsRegular() {
+			entries[normalizeEntryName(hdr.Name)] = makeEntry(counter.n, hdr.Size)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sectionReader is a FileReader over part of the archive
+type sectionReader struct {
+	*io.SectionReader
+}
+
+func (sectionReader) Close() error { return nil }
+
+// limitedReadCloser reads the entry from a decompressed stream and closes the stream
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// entryReader is a FileReader for an entry that can only be read from its start. Seeking forward discards bytes and
+// seeking backward opens the entry again.
+type entryReader struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser // the open entry, nil until the first read
+	pos  int64         // the position of rc within the entry
+	size int64
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	if e.rc == nil {
+		rc, err := e.open()
+
+		if err != nil {
+			return 0, err
+		}
+
+		e.rc = rc
+		e.pos = 0
+	}
+
+	n, err := e.rc.Read(p)
+	e.pos += int64(n)
+	return n, err
+}
+
+func (e *entryReader) Seek(offset int64, whence int) (int64, error) {
+	target := offset
+
+	if whence == io.SeekCurrent {
+		target += e.pos
+	} else if whence == io.SeekEnd {
+		target += e.size
+	}
+
+	if target < 0 {
+		return 0, flog.Raise("seek to a negative position")
+	}
+
+	if target < e.pos && e.rc != nil {
+		_ = e.rc.Close()
+		e.rc = nil
+	}
+
+	if e.rc == nil {
+		rc, err := e.open()
+
+		if err != nil {
+			return 0, err
+		}
+
+		e.rc = rc
+		e.pos = 0
+	}
+
+	n, err := io.CopyN(ioutil.Discard, e.rc, target-e.pos)
+	e.pos += n
+
+	if err != nil && err != io.EOF {
+		return e.pos, err
+	}
+
+	return e.pos, nil
+}
+
+func (e *entryReader) Close() error {
+	if e.rc == nil {
+		return nil
+	}
+
+	return e.rc.Close()
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// archiveTestFiles are the files written to each test archive, by their names in the archive
+var archiveTestFiles = map[string][]byte{
+	"./readme.txt":           []byte("firmware bundle"),
+	"firmware/image.bin":     makeTestData(100000),
+	"/firmware/checksum.txt": []byte("0123456789abcdef"),
+}
+
+// writeTestTar writes the test files to a tar archive, with a directory entry for good measure
+func writeTestTar(t *testing.T, w io.Writer) {
+	tw := tar.NewWriter(w)
+	_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "firmware/", Mode: 0755})
+
+	for name, data := range archiveTestFiles {
+		hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: int64(len(data)), Mode: 0644}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, _ = tw.Write(data)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+// writeTestArchives writes the test files to a tar, a tar.gz and a zip archive in dir, returning their paths
+func writeTestArchives(t *testing.T, dir string) []string {
+	plain := bytes.Buffer{}
+	writeTestTar(t, &plain)
+
+	gzipped := bytes.Buffer{}
+	gz := gzip.NewWriter(&gzipped)
+	writeTestTar(t, gz)
+	_ = gz.Close()
+
+	zipped := bytes.Buffer{}
+	zw := zip.NewWriter(&zipped)
+	_, _ = zw.Create("firmware/")
+
+	for name, data := range archiveTestFiles {
+		// the readme is stored, the rest are compressed
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+
+		if name == "./readme.txt" {
+			hdr.Method = zip.Store
+		}
+
+		w, err := zw.CreateHeader(hdr)
+
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		_, _ = w.Write(data)
+	}
+
+	_ = zw.Close()
+
+	var paths []string
+
+	for name, data := range map[string][]byte{"bundle.tar": plain.Bytes(), "bundle.tar.gz": gzipped.Bytes(),
+		"bundle.zip": zipped.Bytes()} {
+		path := filepath.Join(dir, name)
+
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+func TestArchiveStore(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	want := map[string][]byte{
+		"readme.txt":            archiveTestFiles["./readme.txt"],
+		"firmware/image.bin":    archiveTestFiles["firmware/image.bin"],
+		"firmware/checksum.txt": archiveTestFiles["/firmware/checksum.txt"],
+		"/firmware/image.bin":   archiveTestFiles["firmware/image.bin"],
+	}
+
+	for _, path := range writeTestArchives(t, dir) {
+		store, err := NewArchiveStore(path)
+
+		if msg, ok := tcore.TErr("NewArchiveStore("+path+")", err); !ok {
+			t.Fatal(msg)
+		}
+
+		for name, data := range want {
+			got, err := store.Get(name)
+
+			if err != nil {
+				t.Errorf("%s: store.Get('%s'): %s", filepath.Base(path), name, err.Error())
+			} else if !bytes.Equal(got.Data, data) {
+				t.Errorf("%s: store.Get('%s') returned different data", filepath.Base(path), name)
+			}
+		}
+
+		_, err = store.Get("firmware")
+		assertErrCode(t, filepath.Base(path)+": store.Get(firmware)", err, cor.ErrNotFound)
+
+		_, err = store.Get("missing.bin")
+		assertErrCode(t, filepath.Base(path)+": store.Get(missing.bin)", err, cor.ErrNotFound)
+
+		err = store.Put(makeTestFile("readme.txt", 10))
+		assertErrCode(t, filepath.Base(path)+": store.Put(readme.txt)", err, cor.ErrAccess)
+
+		_, err = AsStreamer(store).Create("new.bin")
+		assertErrCode(t, filepath.Base(path)+": Create(new.bin)", err, cor.ErrAccess)
+
+		store.Terminate()
+
+		if _, err = store.Get("readme.txt"); err == nil {
+			t.Errorf("%s: Get should fail after Terminate", filepath.Base(path))
+		}
+	}
+}
+
+func TestArchiveStoreSeek(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	data := archiveTestFiles["firmware/image.bin"]
+
+	for _, path := range writeTestArchives(t, dir) {
+		store, _ := NewArchiveStore(path)
+		r, size, err := AsStreamer(store).Open("firmware/image.bin")
+
+		if msg, ok := tcore.TErr(filepath.Base(path)+": Open", err); !ok {
+			t.Fatal(msg)
+		}
+
+		if msg, ok := tcore.TAssertInt("size", int(size), len(data)); !ok {
+			t.Error(msg)
+		}
+
+		// forward, then back again, as a transfer does when it resends a window
+		for _, offset := range []int64{60000, 512, 99999} {
+			if _, err = r.Seek(offset, io.SeekStart); err != nil {
+				t.Fatalf("%s: Seek(%d): %s", filepath.Base(path), offset, err.Error())
+			}
+
+			got, _ := ioutil.ReadAll(io.LimitReader(r, 512))
+
+			if !bytes.Equal(got, data[offset:min64(offset+512, int64(len(data)))]) {
+				t.Errorf("%s: the data at %d differs", filepath.Base(path), offset)
+			}
+		}
+
+		_ = r.Close()
+		store.Terminate()
+	}
+}
+
+func TestNewArchiveStoreInvalid(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "bundle.zip")
+	_ = ioutil.WriteFile(path, []byte("PK\x03\x04 this is not really a zip file"), 0644)
+
+	if _, err := NewArchiveStore(path); err == nil {
+		t.Error("a corrupt archive should not be accepted")
+	}
+
+	if _, err := NewArchiveStore(filepath.Join(dir, "missing.tar")); err == nil {
+		t.Error("a missing archive should not be accepted")
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,313 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+var _ Store = (*dirStore)(nil)
+var _ Streamer = (*dirStore)(nil)
+var _ Checker = (*dirStore)(nil)
+
+// dirStore implements the Store and Streamer interfaces for storing and retrieving files in a directory on disk
+type dirStore struct {
+	root       string         // the absolute path of the directory, with symlinks resolved
+	mx         sync.RWMutex   // protects terminated
+	terminated bool           // when true, all functions return an error
+	writes     sync.WaitGroup // the files being written, which Terminate waits for
+}
+
+// NewDirStore creates a new Store for storing and retrieving files in the directory root, which must exist. File names
+// are paths relative to root, with either slash as the separator. Names that would reach outside of root, whether with
+// "..", an absolute path or a symlink, are refused with an ErrAccess error. Uploads are written to a temporary file
+// that is renamed into place once it is complete, so a reader never sees a partial file.
+func NewDirStore(root string) (Store, error) {
+	abs, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	abs, err = filepath.EvalSymlinks(abs)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	info, err := os.Stat(abs)
+
+	if err != nil {
+		return nil, flog.Wrap(err)
+	} else if !info.IsDir() {
+		return nil, flog.Raisef("'%s' is not a directory", root)
+	}
+
+	return &dirStore{root: abs}, nil
+}
+
+// Get returns a file from the store
+func (d *dirStore) Get(name string) (cor.File, error) {
+	r, _, err := d.Open(name)
+
+	if err != nil {
+		return cor.File{}, err
+	}
+
+	defer func() { _ = r.Close() }()
+	data, err := ioutil.ReadAll(r)
+
+	if err != nil {
+		return cor.File{}, flog.Wrap(err)
+	}
+
+	return cor.File{Name: name, Data: data}, nil
+}
+
+// Put writes the file to a temporary file in the same directory, then renames it into place
+func (d *dirStore) Put(f cor.File) error {
+	w, err := d.Create(f.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(f.Data); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	return w.Commit()
+}
+
+// Open opens the file for reading
+func (d *dirStore) Open(name string) (FileReader, int64, error) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	if d.terminated {
+		return nil, 0, flog.Raise("the dirStore has been terminated")
+	}
+
+	path, err := d.resolve(name)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return nil, 0, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+	} else if os.IsPermission(err) {
+		return nil, 0, cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be read", name)
+	} else if err != nil {
+		return nil, 0, flog.Wrap(err)
+	}
+
+	info, err := f.Stat()
+
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, flog.Wrap(err)
+	} else if info.IsDir() {
+		_ = f.Close()
+		return nil, 0, cor.NewErrf(cor.ErrNotFound, "'%s' is a directory", name)
+	}
+
+	return f, info.Size(), nil
+}
+
+// Exists returns true if the named file is a regular file within root
+func (d *dirStore) Exists(name string) bool {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	if d.terminated {
+		return false
+	}
+
+	path, err := d.resolve(name)
+
+	if err != nil {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// Create opens a temporary file in the same directory as the named file, which Commit renames into place
+func (d *dirStore) Create(name string) (FileWriter, error) {
+	d.mx.RLock()
+
+	if d.terminated {
+		d.mx.RUnlock()
+		return nil, flog.Raise("the dirStore has been terminated")
+	}
+
+	// registered while holding the lock so that Terminate cannot begin waiting before the write is counted
+	d.writes.Add(1)
+	d.mx.RUnlock()
+
+	tmp, path, err := d.createTemp(name)
+
+	if err != nil {
+		d.writes.Done()
+		return nil, err
+	}
+
+	return &dirWriter{store: d, tmp: tmp, path: path}, nil
+}
+
+// createTemp creates a temporary file next to the path of the named file, returning it along with that path
+func (d *dirStore) createTemp(name string) (*os.File, string, error) {
+	path, err := d.resolve(name)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return nil, "", cor.NewErrf(cor.ErrAccess, "'%s' is a directory", name)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+
+	if os.IsNotExist(err) {
+		return nil, "", cor.NewErrf(cor.ErrNotFound, "the directory of '%s' does not exist", name)
+	} else if os.IsPermission(err) {
+		return nil, "", cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written", name)
+	} else if err != nil {
+		return nil, "", flog.Wrap(err)
+	}
+
+	return tmp, path, nil
+}
+
+// Terminate refuses any further calls to the store, then waits for the files being written to be committed or closed,
+// so that uploads in progress are flushed to disk rather than lost.
+func (d *dirStore) Terminate() {
+	d.mx.Lock()
+	d.terminated = true
+	d.mx.Unlock()
+	d.writes.Wait()
+	flog.Trace("terminated")
+}
+
+// resolve returns the path of the file called name. An ErrAccess error is returned if name is absolute, climbs out of
+// root with "..", or passes through a symlink that leads outside of root.
+func (d *dirStore) resolve(name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+
+	if len(name) == 0 || strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", cor.NewErrf(cor.ErrAccess, "access to '%s' is denied", name)
+	}
+
+	rel := filepath.Clean(filepath.FromSlash(slashed))
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", cor.NewErrf(cor.ErrAccess, "access to '%s' is denied", name)
+	}
+
+	path := filepath.Join(d.root, rel)
+
+	// the file need not exist yet, but the directory it would be written to must stay inside of root
+	resolved, err := filepath.EvalSymlinks(path)
+
+	if os.IsNotExist(err) {
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(path))
+	}
+
+	if os.IsNotExist(err) {
+		// nothing on disk to follow, so nothing can lead outside of root
+		return path, nil
+	} else if err != nil {
+		return "", flog.Wrap(err)
+	}
+
+	if !d.contains(resolved) {
+		return "", cor.NewErrf(cor.ErrAccess, "access to '%s' is denied", name)
+	}
+
+	return path, nil
+}
+
+// contains returns true if path is root or is inside of it
+func (d *dirStore) contains(path string) bool {
+	rel, err := filepath.Rel(d.root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// dirWriter writes a file to a temporary file, which is renamed into place when it is committed
+type dirWriter struct {
+	store *dirStore
+	tmp   *os.File
+	path  string // where the file is renamed to
+	done  bool   // true once the file has been committed or closed
+}
+
+// Write writes to the temporary file
+func (w *dirWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, flog.Raise("the file has been closed")
+	}
+
+	n, err := w.tmp.Write(p)
+
+	if err != nil {
+		return n, cor.NewErrf(cor.ErrDisk, "the file could not be written: %s", err.Error())
+	}
+
+	return n, nil
+}
+
+// Commit flushes the temporary file to disk and renames it into place. ioutil.TempFile creates files that only the
+// owner can read, so the permissions are relaxed to those of a file made by os.Create.
+func (w *dirWriter) Commit() error {
+	if w.done {
+		return flog.Raise("the file has been closed")
+	}
+
+	w.done = true
+	defer w.store.writes.Done()
+	err := w.tmp.Sync()
+
+	if err == nil {
+		err = w.tmp.Chmod(0644)
+	}
+
+	if closeErr := w.tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(w.tmp.Name(), w.path)
+	}
+
+	if err != nil {
+		_ = os.Remove(w.tmp.Name())
+		return cor.NewErrf(cor.ErrDisk, "the file could not be written: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Close discards the temporary file unless it has been committed
+func (w *dirWriter) Close() error {
+	if w.done {
+		return nil
+	}
+
+	w.done = true
+	defer w.store.writes.Done()
+	_ = w.tmp.Close()
+	return os.Remove(w.tmp.Name())
+}
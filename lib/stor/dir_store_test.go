@@ -0,0 +1,290 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// makeTestDir creates a temporary directory, returning its path and a function that removes it
+func makeTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "dirstore")
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+// assertErrCode fails the test unless err is a *cor.Err with the given code
+func assertErrCode(t *testing.T, stm string, err error, code cor.ErrCode) {
+	e, ok := err.(*cor.Err)
+
+	if !ok {
+		t.Errorf("%s: want a *cor.Err with code %s, got %v", stm, code.String(), err)
+		return
+	}
+
+	if msg, ok := tcore.TAssertInt(stm, int(e.Code()), int(code)); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store, err := NewDirStore(dir)
+
+	if msg, ok := tcore.TErr("NewDirStore(dir)", err); !ok {
+		t.Fatal(msg)
+	}
+
+	defer store.Terminate()
+
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for _, name := range []string{"anyfile.txt", "sub/nested.bin", `sub\windows.bin`} {
+		f := makeTestFile(name, 1000)
+
+		if err = store.Put(f); err != nil {
+			t.Errorf("store.Put('%s'): %s", name, err.Error())
+			continue
+		}
+
+		got, err := store.Get(name)
+
+		if err != nil {
+			t.Errorf("store.Get('%s'): %s", name, err.Error())
+		} else if !bytes.Equal(got.Data, f.Data) {
+			t.Errorf("store.Get('%s') returned different data", name)
+		}
+	}
+
+	onDisk, err := ioutil.ReadFile(filepath.Join(dir, "sub", "nested.bin"))
+
+	if msg, ok := tcore.TErr("ioutil.ReadFile", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if !bytes.Equal(onDisk, makeTestData(1000)) {
+		t.Error("the file on disk differs from the file put")
+	}
+
+	// the temporary files have all been renamed into place
+	entries, _ := ioutil.ReadDir(filepath.Join(dir, "sub"))
+
+	if msg, ok := tcore.TAssertInt("files in sub", len(entries), 2); !ok {
+		t.Error(msg)
+	}
+
+	// overwriting replaces the file
+	if err = store.Put(makeTestFile("anyfile.txt", 10)); err != nil {
+		t.Error(err.Error())
+	}
+
+	got, _ := store.Get("anyfile.txt")
+
+	if msg, ok := tcore.TAssertInt("len(got.Data)", len(got.Data), 10); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestDirStoreNotFound(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store, _ := NewDirStore(dir)
+	defer store.Terminate()
+
+	_, err := store.Get("missing.bin")
+	assertErrCode(t, "store.Get(missing.bin)", err, cor.ErrNotFound)
+
+	_, err = store.Get("missing/file.bin")
+	assertErrCode(t, "store.Get(missing/file.bin)", err, cor.ErrNotFound)
+
+	err = store.Put(makeTestFile("missing/file.bin", 10))
+	assertErrCode(t, "store.Put(missing/file.bin)", err, cor.ErrNotFound)
+}
+
+func TestDirStoreTraversal(t *testing.T) {
+	parent, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	root := filepath.Join(parent, "root")
+	outside := filepath.Join(parent, "outside")
+
+	for _, d := range []string{root, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	secret := filepath.Join(outside, "secret.txt")
+
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// symlinks that lead out of root, to a directory and to a file
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skip("symlinks are not supported: " + err.Error())
+	}
+
+	if err := os.Symlink(secret, filepath.Join(root, "secret.txt")); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	store, _ := NewDirStore(root)
+	defer store.Terminate()
+
+	names := []string{
+		"../outside/secret.txt",
+		"a/../../outside/secret.txt",
+		`..\outside\secret.txt`,
+		secret,
+		"/etc/passwd",
+		"escape/secret.txt",
+		"secret.txt",
+		"",
+	}
+
+	for _, name := range names {
+		_, err := store.Get(name)
+		assertErrCode(t, "store.Get("+name+")", err, cor.ErrAccess)
+
+		err = store.Put(makeTestFile(name, 10))
+		assertErrCode(t, "store.Put("+name+")", err, cor.ErrAccess)
+	}
+
+	// nothing outside of root was touched
+	data, _ := ioutil.ReadFile(secret)
+
+	if msg, ok := tcore.TAssertString("secret", string(data), "secret"); !ok {
+		t.Error(msg)
+	}
+
+	entries, _ := ioutil.ReadDir(outside)
+
+	if msg, ok := tcore.TAssertInt("files outside of root", len(entries), 1); !ok {
+		t.Error(msg)
+	}
+
+	// a name that climbs and comes back down inside of root is allowed
+	if err := store.Put(makeTestFile("a/../inside.txt", 10)); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestDirStoreTerminate(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store, _ := NewDirStore(dir)
+	store.Terminate()
+
+	if err := store.Put(makeTestFile("late.txt", 10)); err == nil {
+		t.Error("Put should fail after Terminate")
+	}
+
+	if _, err := store.Get("late.txt"); err == nil {
+		t.Error("Get should fail after Terminate")
+	}
+}
+
+func TestNewDirStoreNotADirectory(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "file.txt")
+	_ = ioutil.WriteFile(file, []byte("x"), 0644)
+
+	if _, err := NewDirStore(file); err == nil {
+		t.Error("a file should not be accepted as the root")
+	}
+
+	if _, err := NewDirStore(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing directory should not be accepted as the root")
+	}
+}
+
+func TestDirStoreStreaming(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store, _ := NewDirStore(dir)
+
+	// a dirStore streams to and from disk without the adapter
+	streamer, ok := AsStreamer(store).(*dirStore)
+
+	if !ok {
+		t.Fatal("want the dirStore itself")
+	}
+
+	w, err := streamer.Create("streamed.bin")
+
+	if msg, ok := tcore.TErr("streamer.Create", err); !ok {
+		t.Fatal(msg)
+	}
+
+	_, _ = w.Write([]byte("partial"))
+
+	// an uncommitted file is not visible
+	if _, err = os.Stat(filepath.Join(dir, "streamed.bin")); !os.IsNotExist(err) {
+		t.Error("the file should not exist before Commit")
+	}
+
+	// Terminate waits for the file being written
+	terminated := make(chan struct{})
+
+	go func() {
+		store.Terminate()
+		close(terminated)
+	}()
+
+	select {
+	case <-terminated:
+		t.Fatal("Terminate returned while a file was being written")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a file opened before Terminate may still be written and committed, but no new files may be created
+	if _, err = w.Write([]byte(" and more")); err != nil {
+		t.Errorf("Write should succeed after Terminate: %s", err.Error())
+	}
+
+	if _, err = streamer.Create("late.bin"); err == nil {
+		t.Error("Create should fail after Terminate")
+	}
+
+	if err = w.Commit(); err != nil {
+		t.Errorf("Commit should succeed after Terminate: %s", err.Error())
+	}
+
+	select {
+	case <-terminated:
+	case <-time.After(time.Second):
+		t.Fatal("Terminate did not return after the file was committed")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "streamed.bin"))
+
+	if msg, ok := tcore.TErr("ioutil.ReadFile", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertString("streamed.bin", string(data), "partial and more"); !ok {
+		t.Error(msg)
+	}
+}
@@ -3,6 +3,8 @@
 package stor
 
 import (
+	"container/list"
+	"math"
 	"sync"
 
 	"github.com/webern/flog"
@@ -10,33 +12,88 @@ import (
 )
 
 var _ Store = (*memStore)(nil)
+var _ Quota = (*memStore)(nil)
+var _ Checker = (*memStore)(nil)
 
 // memStore implements the Store interface for storing and retrieving files in a memory cache.
 type memStore struct {
-	mx         sync.RWMutex      // protects all data fields
-	files      map[string][]byte // stores the files
-	terminated bool              // when true, all functions return an error
+	mx          sync.RWMutex             // protects all data fields
+	files       map[string]*list.Element // stores the files, each element holds a *memFile
+	lru         *list.List               // the files from most to least recently used
+	used        int64                    // the total number of bytes held
+	budget      int64                    // the most bytes the store may hold, 0 for no limit
+	maxFileSize int64                    // the largest file the store accepts, 0 for no limit
+	pinned      map[string]bool          // the names of the files that are never evicted
+	snapshot    string                   // the file the store is saved to when it is terminated, if not empty
+	terminated  bool                     // when true, all functions return an error
 }
 
-// NewMemStore creates a new Store for storing and retrieving files to/from a memory cache.
-func NewMemStore() Store {
-	return &memStore{
+// memFile is a file held by a memStore
+type memFile struct {
+	name string
+	data []byte
+}
+
+// MemStoreOption configures the Store created by NewMemStore
+type MemStoreOption func(m *memStore)
+
+// WithBudget limits the total number of bytes the store holds. When a new file would exceed the budget, the least
+// recently used files are evicted to make room for it. A budget of 0 means no limit.
+func WithBudget(bytes int64) MemStoreOption {
+	return func(m *memStore) {
+		m.budget = bytes
+	}
+}
+
+// WithMaxFileSize limits the size of any one file. A limit of 0 means no limit.
+func WithMaxFileSize(bytes int64) MemStoreOption {
+	return func(m *memStore) {
+		m.maxFileSize = bytes
+	}
+}
+
+// WithPinned names files that are never evicted to stay within the budget. The files need not exist yet, they are
+// pinned whenever they are put.
+func WithPinned(names ...string) MemStoreOption {
+	return func(m *memStore) {
+		for _, name := range names {
+			m.pinned[name] = true
+		}
+	}
+}
+
+// NewMemStore creates a new Store for storing and retrieving files to/from a memory cache. Without options it holds
+// any number of files of any size. Put returns an ErrDisk error if a file cannot fit within the limits given by the
+// options.
+func NewMemStore(options ...MemStoreOption) Store {
+	m := &memStore{
 		mx:         sync.RWMutex{},
-		files:      make(map[string][]byte),
+		files:      make(map[string]*list.Element),
+		lru:        list.New(),
+		pinned:     make(map[string]bool),
 		terminated: false,
 	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
 }
 
 // Get returns a file from the store
 func (m *memStore) Get(name string) (cor.File, error) {
-	m.mx.RLock()
-	defer m.mx.RUnlock()
+	// a write lock, since getting a file makes it the most recently used
+	m.mx.Lock()
+	defer m.mx.Unlock()
 
 	if m.terminated {
 		return cor.File{}, flog.Raise("the memStore has been terminated")
 	}
 
-	if b, ok := m.files[name]; ok {
+	if elem, ok := m.files[name]; ok {
+		m.lru.MoveToFront(elem)
+		b := elem.Value.(*memFile).data
 		f := cor.File{}
 		f.Name = name
 		f.Data = make([]byte, len(b), len(b))
@@ -44,10 +101,18 @@ func (m *memStore) Get(name string) (cor.File, error) {
 		return f, nil
 	}
 
-	return cor.File{}, flog.Raisef("the file '%s' was not found", name)
+	return cor.File{}, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+}
+
+// Exists returns true if the store holds the named file, without making it the most recently used
+func (m *memStore) Exists(name string) bool {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	_, ok := m.files[name]
+	return ok && !m.terminated
 }
 
-// Put places a file into the Store
+// Put places a file into the Store, evicting the least recently used files if it would not otherwise fit
 func (m *memStore) Put(f cor.File) error {
 	m.mx.Lock()
 	defer m.mx.Unlock()
@@ -56,16 +121,106 @@ func (m *memStore) Put(f cor.File) error {
 		return flog.Raise("the memStore has been terminated")
 	}
 
+	return m.put(f)
+}
+
+// put places a copy of f into the store. The caller must hold the write lock.
+func (m *memStore) put(f cor.File) error {
+	size := int64(len(f.Data))
+
+	if m.maxFileSize > 0 && size > m.maxFileSize {
+		return cor.NewErrf(cor.ErrDisk, "the file '%s' exceeds the limit of %d bytes per file", f.Name, m.maxFileSize)
+	}
+
+	existing, replacing := m.files[f.Name]
+
+	if m.budget > 0 {
+		pinned := m.pinnedBytes()
+
+		// the file being replaced makes way for its replacement
+		if replacing && m.pinned[f.Name] {
+			pinned -= int64(len(existing.Value.(*memFile).data))
+		}
+
+		if size > m.budget-pinned {
+			return cor.NewErrf(cor.ErrDisk, "the file '%s' does not fit in the budget of %d bytes", f.Name, m.budget)
+		}
+	}
+
+	if replacing {
+		m.remove(existing)
+	}
+
+	if m.budget > 0 {
+		for elem := m.lru.Back(); elem != nil && m.used+size > m.budget; {
+			prev := elem.Prev()
+
+			if mf := elem.Value.(*memFile); !m.pinned[mf.name] {
+				flog.Trace("evicting " + mf.name)
+				m.remove(elem)
+			}
+
+			elem = prev
+		}
+	}
+
 	b := make([]byte, len(f.Data), len(f.Data))
 	copy(b, f.Data)
-	m.files[f.Name] = b
+	m.files[f.Name] = m.lru.PushFront(&memFile{name: f.Name, data: b})
+	m.used += size
 	return nil
 }
 
-// Terminate tells the Store it is about to be destroyed
+// Remaining returns the size of the largest file that can be put, which may require evicting every file that is not
+// pinned. It is the same for every name.
+func (m *memStore) Remaining(name string) int64 {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	remaining := int64(math.MaxInt64)
+
+	if m.budget > 0 {
+		remaining = m.budget - m.pinnedBytes()
+	}
+
+	if m.maxFileSize > 0 && m.maxFileSize < remaining {
+		remaining = m.maxFileSize
+	}
+
+	return remaining
+}
+
+// Terminate tells the Store it is about to be destroyed. If the store was loaded with LoadMemStore, its files are saved
+// to the snapshot file first.
 func (m *memStore) Terminate() {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	defer flog.Trace("terminated")
+
+	if len(m.snapshot) > 0 && !m.terminated {
+		if err := m.saveSnapshot(m.snapshot); err != nil {
+			flog.Error(err.Error())
+		}
+	}
+
 	m.terminated = true
 }
+
+// remove deletes the file held by elem. The caller must hold the write lock.
+func (m *memStore) remove(elem *list.Element) {
+	mf := m.lru.Remove(elem).(*memFile)
+	delete(m.files, mf.name)
+	m.used -= int64(len(mf.data))
+}
+
+// pinnedBytes returns the number of bytes held by pinned files. The caller must hold a lock.
+func (m *memStore) pinnedBytes() int64 {
+	var n int64
+
+	for name := range m.pinned {
+		if elem, ok := m.files[name]; ok {
+			n += int64(len(elem.Value.(*memFile).data))
+		}
+	}
+
+	return n
+}
@@ -102,3 +102,111 @@ func TestMemStore(t *testing.T) {
 		t.Errorf("'%s' was expected to throw an error, but did not", "_, err = mstore.Get(\"nope\")")
 	}
 }
+
+// assertHas fails the test unless the store holds exactly the named files among names
+func assertHas(t *testing.T, store Store, names []string, want map[string]bool) {
+	for _, name := range names {
+		_, err := store.Get(name)
+
+		if has := err == nil; has != want[name] {
+			t.Errorf("has %s: got %t, want %t", name, has, want[name])
+		}
+	}
+}
+
+func TestMemStoreBudget(t *testing.T) {
+	mstore := NewMemStore(WithBudget(300))
+	defer mstore.Terminate()
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := mstore.Put(makeTestFile(name, 100)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// reading a makes b the least recently used, so b is evicted to make room for d
+	_, _ = mstore.Get("a")
+
+	if err := mstore.Put(makeTestFile("d", 100)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := []string{"a", "b", "c", "d"}
+	assertHas(t, mstore, names, map[string]bool{"a": true, "c": true, "d": true})
+
+	// replacing a file frees its bytes first, nothing else is evicted
+	if err := mstore.Put(makeTestFile("c", 100)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertHas(t, mstore, names, map[string]bool{"a": true, "c": true, "d": true})
+
+	// a file larger than the budget is refused and nothing is evicted for it
+	err := mstore.Put(makeTestFile("huge", 301))
+	assertErrCode(t, "mstore.Put(huge)", err, cor.ErrDisk)
+	assertHas(t, mstore, names, map[string]bool{"a": true, "c": true, "d": true})
+
+	// a file the size of the budget evicts everything else
+	if err = mstore.Put(makeTestFile("e", 300)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertHas(t, mstore, append(names, "e"), map[string]bool{"e": true})
+}
+
+func TestMemStoreMaxFileSize(t *testing.T) {
+	mstore := NewMemStore(WithMaxFileSize(100))
+	defer mstore.Terminate()
+
+	if err := mstore.Put(makeTestFile("small", 100)); err != nil {
+		t.Error(err.Error())
+	}
+
+	err := mstore.Put(makeTestFile("big", 101))
+	assertErrCode(t, "mstore.Put(big)", err, cor.ErrDisk)
+
+	stm := "Remaining(\"d\")"
+	gotI := int(mstore.(Quota).Remaining("d"))
+	wantI := 100
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestMemStorePinned(t *testing.T) {
+	mstore := NewMemStore(WithBudget(300), WithPinned("boot.img"))
+	defer mstore.Terminate()
+
+	_ = mstore.Put(makeTestFile("boot.img", 200))
+	_ = mstore.Put(makeTestFile("a", 50))
+	_ = mstore.Put(makeTestFile("b", 50))
+
+	// the pinned file is the least recently used, but only a is evicted
+	_, _ = mstore.Get("a")
+	_, _ = mstore.Get("b")
+
+	if err := mstore.Put(makeTestFile("c", 50)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	names := []string{"boot.img", "a", "b", "c"}
+	assertHas(t, mstore, names, map[string]bool{"boot.img": true, "b": true, "c": true})
+
+	// only the unpinned bytes can be freed
+	stm := "Remaining(\"d\")"
+	gotI := int(mstore.(Quota).Remaining("d"))
+	wantI := 100
+	if msg, ok := tcore.TAssertInt(stm, gotI, wantI); !ok {
+		t.Error(msg)
+	}
+
+	err := mstore.Put(makeTestFile("d", 101))
+	assertErrCode(t, "mstore.Put(d)", err, cor.ErrDisk)
+
+	// a pinned file may be replaced by a larger one, since its own bytes make way
+	if err = mstore.Put(makeTestFile("boot.img", 300)); err != nil {
+		t.Error(err.Error())
+	}
+
+	assertHas(t, mstore, names, map[string]bool{"boot.img": true})
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+var _ Store = (*mountStore)(nil)
+var _ Streamer = (*mountStore)(nil)
+var _ Checker = (*mountStore)(nil)
+var _ Quota = (*mountStore)(nil)
+var _ WriteChecker = (*mountStore)(nil)
+
+// Mount places a Store at a prefix of the names served by a mount store
+type Mount struct {
+	Prefix string // e.g. "pxe" serves "pxe/boot.img" as "boot.img" from Store. "" or "/" mounts at the root.
+	Store  Store
+}
+
+// mountStore implements the Store and Streamer interfaces by routing each name to the store mounted at its prefix
+type mountStore struct {
+	mounts []Mount // with normalized prefixes, from longest to shortest
+}
+
+// NewMountStore creates a Store that routes each file to the store mounted at the longest prefix of its name, passing
+// the rest of the name to that store, so that with "pxe" mounted "pxe/boot.img" is "boot.img" in the mounted store.
+// Prefixes match whole path elements, so "pxe" does not match "pxe2/boot.img". Leading slashes are ignored, both in
+// prefixes and in names. A name that falls under no prefix is not found. An error is returned if two mounts have the
+// same prefix. Terminate terminates every mounted store.
+func NewMountStore(mounts []Mount) (Store, error) {
+	m := &mountStore{}
+	seen := make(map[string]bool)
+
+	for _, mount := range mounts {
+		prefix := strings.Trim(mount.Prefix, "/")
+
+		if seen[prefix] {
+			return nil, flog.Raisef("more than one store is mounted at '%s'", mount.Prefix)
+		}
+
+		seen[prefix] = true
+		m.mounts = append(m.mounts, Mount{Prefix: prefix, Store: mount.Store})
+	}
+
+	sort.SliceStable(m.mounts, func(i, j int) bool {
+		return len(m.mounts[i].Prefix) > len(m.mounts[j].Prefix)
+	})
+
+	return m, nil
+}
+
+// Get returns the file from the store mounted at its prefix. The file keeps the name it was asked for.
+func (m *mountStore) Get(name string) (cor.File, error) {
+	store, rest, err := m.route(name)
+
+	if err != nil {
+		return cor.File{}, err
+	}
+
+	f, err := store.Get(rest)
+
+	if err != nil {
+		return cor.File{}, err
+	}
+
+	f.Name = name
+	return f, nil
+}
+
+// Put places the file in the store mounted at its prefix
+func (m *mountStore) Put(f cor.File) error {
+	store, rest, err := m.route(f.Name)
+
+	if err != nil {
+		return err
+	}
+
+	return store.Put(cor.File{Name: rest, Data: f.Data})
+}
+
+// Open opens the file in the store mounted at its prefix
+func (m *mountStore) Open(name string) (FileReader, int64, error) {
+	store, rest, err := m.route(name)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return AsStreamer(store).Open(rest)
+}
+
+// Exists returns true if the store mounted at its prefix holds the file
+func (m *mountStore) Exists(name string) bool {
+	store, rest, err := m.route(name)
+	return err == nil && Exists(store, rest)
+}
+
+// Remaining returns the room for the file in the store mounted at its prefix
+func (m *mountStore) Remaining(name string) int64 {
+	store, rest, err := m.route(name)
+
+	if err != nil {
+		// Create refuses the name
+		return math.MaxInt64
+	}
+
+	return Remaining(store, rest)
+}
+
+// Writable returns false if the store mounted at its prefix refuses to write the file. A name outside every mount is
+// not refused here, Create reports that it was not found.
+func (m *mountStore) Writable(name string) bool {
+	store, rest, err := m.route(name)
+	return err != nil || Writable(store, rest)
+}
+
+// Create creates the file in the store mounted at its prefix
+func (m *mountStore) Create(name string) (FileWriter, error) {
+	store, rest, err := m.route(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return AsStreamer(store).Create(rest)
+}
+
+// Terminate terminates every mounted store
+func (m *mountStore) Terminate() {
+	for _, mount := range m.mounts {
+		mount.Store.Terminate()
+	}
+
+	flog.Trace("terminated")
+}
+
+// route returns the store mounted at the longest prefix of name, and the rest of the name
+func (m *mountStore) route(name string) (Store, string, error) {
+	trimmed := strings.TrimLeft(name, "/")
+
+	for _, mount := range m.mounts {
+		if len(mount.Prefix) == 0 {
+			return mount.Store, trimmed, nil
+		} else if strings.HasPrefix(trimmed, mount.Prefix+"/") {
+			return mount.Store, strings.TrimLeft(trimmed[len(mount.Prefix):], "/"), nil
+		}
+	}
+
+	return nil, "", cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found, nothing is mounted there", name)
+}
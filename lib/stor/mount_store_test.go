@@ -0,0 +1,94 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestMountStore(t *testing.T) {
+	pxe := NewMemStore()
+	upload := NewMemStore()
+	root := NewMemStore()
+	_ = pxe.Put(makeTestFile("boot.img", 100))
+	_ = root.Put(makeTestFile("pxe2/boot.img", 10))
+
+	store, err := NewMountStore([]Mount{{"/", root}, {"pxe", pxe}, {"/upload/logs/", upload}})
+
+	if msg, ok := tcore.TErr("NewMountStore", err); !ok {
+		t.Fatal(msg)
+	}
+
+	defer store.Terminate()
+
+	// the prefix is stripped, and leading slashes are ignored
+	for _, name := range []string{"pxe/boot.img", "/pxe/boot.img", "pxe//boot.img"} {
+		got, err := store.Get(name)
+
+		if err != nil {
+			t.Errorf("store.Get('%s'): %s", name, err.Error())
+			continue
+		}
+
+		if msg, ok := tcore.TAssertString("got.Name", got.Name, name); !ok {
+			t.Error(msg)
+		}
+
+		if !bytes.Equal(got.Data, makeTestData(100)) {
+			t.Errorf("store.Get('%s') returned different data", name)
+		}
+	}
+
+	// prefixes match whole path elements, so this falls through to the root mount
+	got, err := store.Get("pxe2/boot.img")
+
+	if err != nil {
+		t.Error(err.Error())
+	} else if msg, ok := tcore.TAssertInt("len(got.Data)", len(got.Data), 10); !ok {
+		t.Error(msg)
+	}
+
+	// the longest prefix wins
+	if err = store.Put(makeTestFile("upload/logs/device1.log", 50)); err != nil {
+		t.Error(err.Error())
+	}
+
+	if _, err = upload.Get("device1.log"); err != nil {
+		t.Error("the upload was not routed to the upload store: " + err.Error())
+	}
+
+	_, err = store.Get("pxe/missing.img")
+	assertErrCode(t, "store.Get(pxe/missing.img)", err, cor.ErrNotFound)
+}
+
+func TestMountStoreUnmounted(t *testing.T) {
+	store, _ := NewMountStore([]Mount{{"pxe", NewMemStore()}, {"cfg", ReadOnly(NewMemStore())}})
+	defer store.Terminate()
+
+	_, err := store.Get("other/file.bin")
+	assertErrCode(t, "store.Get(other/file.bin)", err, cor.ErrNotFound)
+
+	err = store.Put(makeTestFile("other/file.bin", 10))
+	assertErrCode(t, "store.Put(other/file.bin)", err, cor.ErrNotFound)
+
+	// the prefix alone names the mount, not a file in it
+	_, err = store.Get("pxe")
+	assertErrCode(t, "store.Get(pxe)", err, cor.ErrNotFound)
+
+	// each mount keeps its own policy
+	err = store.Put(makeTestFile("cfg/device.cfg", 10))
+	assertErrCode(t, "store.Put(cfg/device.cfg)", err, cor.ErrAccess)
+
+	_, err = AsStreamer(store).Create("cfg/device.cfg")
+	assertErrCode(t, "Create(cfg/device.cfg)", err, cor.ErrAccess)
+}
+
+func TestNewMountStoreDuplicate(t *testing.T) {
+	if _, err := NewMountStore([]Mount{{"pxe", NewMemStore()}, {"/pxe/", NewMemStore()}}); err == nil {
+		t.Error("two stores mounted at the same prefix should be refused")
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"math"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+var _ Store = (*overlayStore)(nil)
+var _ Streamer = (*overlayStore)(nil)
+var _ Checker = (*overlayStore)(nil)
+var _ Quota = (*overlayStore)(nil)
+var _ WriteChecker = (*overlayStore)(nil)
+
+// overlayStore implements the Store and Streamer interfaces over a stack of other stores
+type overlayStore struct {
+	layers   []Store // from the top of the stack to the bottom
+	noShadow bool    // when true, a file may not be written if it exists in another layer
+}
+
+// OverlayOption configures the Store created by NewOverlayStore
+type OverlayOption func(o *overlayStore)
+
+// WithoutShadowing refuses, with an ErrAccess error, to write a file that exists in any layer other than the one it
+// would be written to, so that uploads can never hide the files of a base layer
+func WithoutShadowing() OverlayOption {
+	return func(o *overlayStore) {
+		o.noShadow = true
+	}
+}
+
+// NewOverlayStore creates a Store that presents the layers, given from top to bottom, as one set of files. A file is
+// read from the first layer that has it. A file is written to the first layer that accepts writes, skipping layers
+// that refuse it by WriteChecker or with an ErrAccess error, such as an archive store. A layer that returns an error
+// other than a *cor.Err is taken not to have the file, as the server does with a single store. Terminate terminates
+// every layer.
+func NewOverlayStore(layers []Store, options ...OverlayOption) Store {
+	o := &overlayStore{layers: layers}
+
+	for _, option := range options {
+		option(o)
+	}
+
+	return o
+}
+
+// Get returns the file from the first layer that has it
+func (o *overlayStore) Get(name string) (cor.File, error) {
+	for _, layer := range o.layers {
+		f, err := layer.Get(name)
+
+		if err == nil || !isNotFound(err) {
+			return f, err
+		}
+	}
+
+	return cor.File{}, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+}
+
+// Put writes the file to the first layer that accepts it
+func (o *overlayStore) Put(f cor.File) error {
+	w, err := o.Create(f.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(f.Data); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	return w.Commit()
+}
+
+// Open opens the file in the first layer that has it
+func (o *overlayStore) Open(name string) (FileReader, int64, error) {
+	for _, layer := range o.layers {
+		r, size, err := AsStreamer(layer).Open(name)
+
+		if err == nil || !isNotFound(err) {
+			return r, size, err
+		}
+	}
+
+	return nil, 0, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+}
+
+// Exists returns true if any layer holds the file
+func (o *overlayStore) Exists(name string) bool {
+	for _, layer := range o.layers {
+		if Exists(layer, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writable returns true if any layer accepts the file
+func (o *overlayStore) Writable(name string) bool {
+	for _, layer := range o.layers {
+		if Writable(layer, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Remaining returns the room for the file in the first layer that accepts it. Layers are asked with Writable, so that
+// nothing is created in them.
+func (o *overlayStore) Remaining(name string) int64 {
+	for _, layer := range o.layers {
+		if Writable(layer, name) {
+			return Remaining(layer, name)
+		}
+	}
+
+	return math.MaxInt64
+}
+
+// Create creates the file in the first layer that accepts it. Layers that refuse it by Writable are passed over without
+// calling their Create, as are layers whose Create refuses it with an ErrAccess error.
+func (o *overlayStore) Create(name string) (FileWriter, error) {
+	for i, layer := range o.layers {
+		if !Writable(layer, name) {
+			continue
+		}
+
+		w, err := AsStreamer(layer).Create(name)
+
+		if e, ok := err.(*cor.Err); ok && e.Code() == cor.ErrAccess {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if o.noShadow {
+			if err = o.checkShadow(name, i); err != nil {
+				_ = w.Close()
+				return nil, err
+			}
+		}
+
+		return w, nil
+	}
+
+	return nil, cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written, no layer accepts writes", name)
+}
+
+// Terminate terminates every layer
+func (o *overlayStore) Terminate() {
+	for _, layer := range o.layers {
+		layer.Terminate()
+	}
+
+	flog.Trace("terminated")
+}
+
+// checkShadow returns an ErrAccess error if the file exists in any layer but the one at index skip
+func (o *overlayStore) checkShadow(name string, skip int) error {
+	for i, layer := range o.layers {
+		if i == skip {
+			continue
+		}
+
+		r, _, err := AsStreamer(layer).Open(name)
+
+		if err == nil {
+			_ = r.Close()
+			return cor.NewErrf(cor.ErrAccess, "the file '%s' exists in another layer and cannot be shadowed", name)
+		} else if !isNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isNotFound returns true if err means that a store does not have a file. A store that knows why it failed returns a
+// *cor.Err, anything else is taken to mean the file is not there.
+func isNotFound(err error) bool {
+	e, ok := err.(*cor.Err)
+	return !ok || e.Code() == cor.ErrNotFound
+}
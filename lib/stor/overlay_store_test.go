@@ -0,0 +1,201 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// createCounter is a Store layer that counts the calls to its Create
+type createCounter struct {
+	Streamer
+	creates int
+}
+
+func (c *createCounter) Get(name string) (cor.File, error) {
+	return cor.File{}, cor.NewErrf(cor.ErrNotFound, "the file '%s' was not found", name)
+}
+
+func (c *createCounter) Put(f cor.File) error {
+	return cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written", f.Name)
+}
+
+func (c *createCounter) Terminate() {}
+
+func (c *createCounter) Create(name string) (FileWriter, error) {
+	c.creates++
+	return c.Streamer.Create(name)
+}
+
+// makeTestOverlay creates an overlay of a memory store over the test tar archive
+func makeTestOverlay(t *testing.T, dir string, options ...OverlayOption) Store {
+	writeTestArchives(t, dir)
+	base, err := NewArchiveStore(filepath.Join(dir, "bundle.tar"))
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return NewOverlayStore([]Store{NewMemStore(), base}, options...)
+}
+
+func TestOverlayStore(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store := makeTestOverlay(t, dir)
+	defer store.Terminate()
+
+	// read through to the base layer
+	got, err := store.Get("readme.txt")
+
+	if msg, ok := tcore.TErr("store.Get(readme.txt)", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertString("readme.txt", string(got.Data), "firmware bundle"); !ok {
+		t.Error(msg)
+	}
+
+	// uploads go to the top layer, the archive refuses them
+	if err = store.Put(makeTestFile("crash.dump", 1000)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, err = store.Get("crash.dump")
+
+	if err != nil {
+		t.Error(err.Error())
+	} else if !bytes.Equal(got.Data, makeTestData(1000)) {
+		t.Error("the uploaded file differs")
+	}
+
+	// a file in the top layer shadows the base
+	if err = store.Put(cor.File{Name: "readme.txt", Data: []byte("replaced")}); err != nil {
+		t.Error(err.Error())
+	}
+
+	r, size, err := AsStreamer(store).Open("readme.txt")
+
+	if msg, ok := tcore.TErr("Open(readme.txt)", err); !ok {
+		t.Fatal(msg)
+	}
+
+	_ = r.Close()
+
+	if msg, ok := tcore.TAssertInt("size", int(size), len("replaced")); !ok {
+		t.Error(msg)
+	}
+
+	_, err = store.Get("missing.bin")
+	assertErrCode(t, "store.Get(missing.bin)", err, cor.ErrNotFound)
+
+	_, _, err = AsStreamer(store).Open("missing.bin")
+	assertErrCode(t, "Open(missing.bin)", err, cor.ErrNotFound)
+}
+
+func TestOverlayStoreWithoutShadowing(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	store := makeTestOverlay(t, dir, WithoutShadowing())
+	defer store.Terminate()
+
+	err := store.Put(cor.File{Name: "firmware/image.bin", Data: []byte("not golden")})
+	assertErrCode(t, "store.Put(firmware/image.bin)", err, cor.ErrAccess)
+
+	got, _ := store.Get("firmware/image.bin")
+
+	if !bytes.Equal(got.Data, archiveTestFiles["firmware/image.bin"]) {
+		t.Error("the base file was shadowed")
+	}
+
+	// files that only exist in the writable layer may still be replaced
+	for _, size := range []int{100, 10} {
+		if err = store.Put(makeTestFile("upload/log.txt", size)); err != nil {
+			t.Error(err.Error())
+		}
+	}
+
+	got, _ = store.Get("upload/log.txt")
+
+	if msg, ok := tcore.TAssertInt("len(got.Data)", len(got.Data), 10); !ok {
+		t.Error(msg)
+	}
+}
+
+func TestOverlayStoreReadOnly(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	writeTestArchives(t, dir)
+	base, _ := NewArchiveStore(filepath.Join(dir, "bundle.zip"))
+	store := NewOverlayStore([]Store{base})
+	defer store.Terminate()
+
+	err := store.Put(makeTestFile("upload.bin", 10))
+	assertErrCode(t, "store.Put(upload.bin)", err, cor.ErrAccess)
+
+	if _, err = store.Get("readme.txt"); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestOverlayStoreRemaining(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	writeTestArchives(t, dir)
+	archive, _ := NewArchiveStore(filepath.Join(dir, "bundle.tar"))
+	uploads := filepath.Join(dir, "uploads")
+
+	if err := os.Mkdir(uploads, 0755); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	disk, err := NewDirStore(uploads)
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the read-only layers are passed over, the memory store is the first that accepts writes
+	store := NewOverlayStore([]Store{archive, ReadOnly(disk), NewMemStore(WithMaxFileSize(100))})
+	defer store.Terminate()
+
+	if msg, ok := tcore.TAssertInt("Remaining(upload.bin)", int(Remaining(store, "upload.bin")), 100); !ok {
+		t.Error(msg)
+	}
+
+	// asking a writable directory layer creates nothing in it
+	counter := &createCounter{Streamer: disk.(Streamer)}
+	store = NewOverlayStore([]Store{archive, counter})
+
+	if msg, ok := tcore.TAssertInt("Remaining(upload.bin)", int(Remaining(store, "upload.bin")), math.MaxInt64); !ok {
+		t.Error(msg)
+	}
+
+	if !Writable(store, "upload.bin") {
+		t.Error("the overlay should accept writes to its directory layer")
+	}
+
+	if msg, ok := tcore.TAssertInt("creates", counter.creates, 0); !ok {
+		t.Error(msg)
+	}
+
+	if entries, _ := ioutil.ReadDir(uploads); len(entries) != 0 {
+		t.Errorf("the directory layer should be empty, it holds %d entries", len(entries))
+	}
+
+	if Writable(NewOverlayStore([]Store{archive, ReadOnly(disk)}), "upload.bin") {
+		t.Error("an overlay of read-only layers should not accept writes")
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"github.com/webern/tftp/lib/cor"
+)
+
+var _ Store = readOnlyStore{}
+var _ Streamer = readOnlyStore{}
+var _ Checker = readOnlyStore{}
+var _ WriteChecker = readOnlyStore{}
+
+// readOnlyStore implements the Store and Streamer interfaces by reading from another store and refusing all writes
+type readOnlyStore struct {
+	store Store
+}
+
+// ReadOnly returns a Store that reads from s, and refuses to write to it with an ErrAccess error. It does not pass on
+// the Quota of s, since nothing can be written to it.
+func ReadOnly(s Store) Store {
+	return readOnlyStore{store: s}
+}
+
+// Get returns a file from the underlying store
+func (r readOnlyStore) Get(name string) (cor.File, error) {
+	return r.store.Get(name)
+}
+
+// Put is refused
+func (r readOnlyStore) Put(f cor.File) error {
+	return cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written, the store is read-only", f.Name)
+}
+
+// Open opens a file in the underlying store
+func (r readOnlyStore) Open(name string) (FileReader, int64, error) {
+	return AsStreamer(r.store).Open(name)
+}
+
+// Exists returns true if the underlying store holds the file
+func (r readOnlyStore) Exists(name string) bool {
+	return Exists(r.store, name)
+}
+
+// Writable returns false, all writes are refused
+func (r readOnlyStore) Writable(name string) bool {
+	return false
+}
+
+// Create is refused
+func (r readOnlyStore) Create(name string) (FileWriter, error) {
+	return nil, cor.NewErrf(cor.ErrAccess, "the file '%s' cannot be written, the store is read-only", name)
+}
+
+// Terminate terminates the underlying store
+func (r readOnlyStore) Terminate() {
+	r.store.Terminate()
+}
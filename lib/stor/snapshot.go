@@ -0,0 +1,193 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
+
+// A snapshot is a tar archive holding one entry per file. The sha256 of each file is stored in a PAX record of its
+// entry, under paxChecksum, and checked when the snapshot is restored. Entries are written from the least to the most
+// recently used file, so that a restored store evicts files in the same order.
+const paxChecksum = "WEBERN.tftp.sha256"
+
+var _ Snapshotter = (*memStore)(nil)
+
+// Snapshotter is optionally implemented by a Store that can save all of its files to a single archive and load them
+// back
+type Snapshotter interface {
+	// Snapshot writes every file in the store to w
+	Snapshot(w io.Writer) error
+
+	// Restore adds the files in a snapshot read from r to the store. If any file in the snapshot is corrupt, an error
+	// is returned and no files are added.
+	Restore(r io.Reader) error
+}
+
+// LoadMemStore creates a memory store that is saved to the snapshot file at path when it is terminated. If the file
+// exists, the store starts with the files it holds, and an error is returned if the snapshot is corrupt.
+func LoadMemStore(path string, options ...MemStoreOption) (Store, error) {
+	m := NewMemStore(options...).(*memStore)
+	m.snapshot = path
+	f, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, flog.Wrap(err)
+	}
+
+	defer func() { _ = f.Close() }()
+
+	if err = m.Restore(f); err != nil {
+		return nil, flog.Raisef("the snapshot '%s' could not be restored: %s", path, err.Error())
+	}
+
+	return m, nil
+}
+
+// Snapshot writes every file in the store to w as a tar archive
+func (m *memStore) Snapshot(w io.Writer) error {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+
+	if m.terminated {
+		return flog.Raise("the memStore has been terminated")
+	}
+
+	return m.writeSnapshot(w)
+}
+
+// Restore reads a tar archive written by Snapshot and puts each of its files, replacing files by the same name
+func (m *memStore) Restore(r io.Reader) error {
+	files, err := readSnapshot(r)
+
+	if err != nil {
+		return err
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.terminated {
+		return flog.Raise("the memStore has been terminated")
+	}
+
+	for _, f := range files {
+		if err = m.put(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeSnapshot writes the files to w. The caller must hold a lock.
+func (m *memStore) writeSnapshot(w io.Writer) error {
+	tw := tar.NewWriter(w)
+
+	for elem := m.lru.Back(); elem != nil; elem = elem.Prev() {
+		mf := elem.Value.(*memFile)
+		sum := sha256.Sum256(mf.data)
+		hdr := &tar.Header{
+			Typeflag:   tar.TypeReg,
+			Name:       mf.name,
+			Size:       int64(len(mf.data)),
+			Mode:       0644,
+			Format:     tar.FormatPAX,
+			PAXRecords: map[string]string{paxChecksum: hex.EncodeToString(sum[:])},
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return flog.Wrap(err)
+		}
+
+		if _, err := tw.Write(mf.data); err != nil {
+			return flog.Wrap(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return flog.Wrap(err)
+	}
+
+	return nil
+}
+
+// saveSnapshot writes the files to a temporary file that is renamed to path once it is complete, so that a crash part
+// way through leaves the previous snapshot in place. The caller must hold a lock.
+func (m *memStore) saveSnapshot(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+
+	if err != nil {
+		return flog.Wrap(err)
+	}
+
+	err = m.writeSnapshot(tmp)
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return flog.Raisef("the snapshot '%s' could not be saved: %s", path, err.Error())
+	}
+
+	return nil
+}
+
+// readSnapshot reads the files in a snapshot, checking each against its checksum
+func readSnapshot(r io.Reader) ([]cor.File, error) {
+	var files []cor.File
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, flog.Wrap(err)
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		want, ok := hdr.PAXRecords[paxChecksum]
+
+		if !ok {
+			return nil, flog.Raisef("the file '%s' has no checksum", hdr.Name)
+		}
+
+		data, err := ioutil.ReadAll(tr)
+
+		if err != nil {
+			return nil, flog.Wrap(err)
+		}
+
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+			return nil, flog.Raisef("the file '%s' does not match its checksum", hdr.Name)
+		}
+
+		files = append(files, cor.File{Name: hdr.Name, Data: data})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/webern/tcore"
+	"github.com/webern/tftp/lib/cor"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	mstore := NewMemStore()
+	defer mstore.Terminate()
+
+	for _, name := range []string{"a", "b", "c"} {
+		_ = mstore.Put(makeTestFile(name, 100))
+	}
+
+	// a is now the most recently used
+	_, _ = mstore.Get("a")
+
+	snapshot := bytes.Buffer{}
+
+	if err := mstore.(Snapshotter).Snapshot(&snapshot); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	restored := NewMemStore(WithBudget(300))
+	defer restored.Terminate()
+
+	if err := restored.(Snapshotter).Restore(&snapshot); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	got, err := restored.Get("b")
+
+	if msg, ok := tcore.TErr("restored.Get(b)", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if !bytes.Equal(got.Data, makeTestData(100)) {
+		t.Error("the restored file differs from the original")
+	}
+
+	// the order of use was restored as well, so c is the least recently used and the first to be evicted
+	_ = restored.Put(makeTestFile("d", 100))
+	assertHas(t, restored, []string{"a", "b", "c", "d"}, map[string]bool{"a": true, "b": true, "d": true})
+}
+
+func TestRestoreCorrupt(t *testing.T) {
+	mstore := NewMemStore()
+	defer mstore.Terminate()
+	_ = mstore.Put(makeTestFile("good", 100))
+	_ = mstore.Put(cor.File{Name: "firmware.bin", Data: bytes.Repeat([]byte("firmware"), 100)})
+	snapshot := bytes.Buffer{}
+
+	if err := mstore.(Snapshotter).Snapshot(&snapshot); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// flip a byte of the file's contents, leaving the archive itself intact
+	corrupt := snapshot.Bytes()
+	i := bytes.Index(corrupt, []byte("firmwarefirmware"))
+
+	if i < 0 {
+		t.Fatal("the file contents were not found in the snapshot")
+	}
+
+	corrupt[i] = 'F'
+	restored := NewMemStore()
+	defer restored.Terminate()
+
+	if err := restored.(Snapshotter).Restore(bytes.NewReader(corrupt)); err == nil {
+		t.Error("a corrupt snapshot should not be restored")
+	}
+
+	// not even the files before the corrupt one are served
+	assertHas(t, restored, []string{"good", "firmware.bin"}, map[string]bool{})
+}
+
+func TestRestoreMissingChecksum(t *testing.T) {
+	archive := bytes.Buffer{}
+	tw := tar.NewWriter(&archive)
+	_ = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "unchecked", Size: 5, Mode: 0644})
+	_, _ = tw.Write([]byte("hello"))
+	_ = tw.Close()
+
+	mstore := NewMemStore()
+	defer mstore.Terminate()
+
+	if err := mstore.(Snapshotter).Restore(&archive); err == nil {
+		t.Error("a file without a checksum should not be restored")
+	}
+}
+
+func TestLoadMemStore(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+
+	path := filepath.Join(dir, "snapshot.tar")
+
+	// the snapshot does not exist yet, so the store starts empty
+	first, err := LoadMemStore(path)
+
+	if msg, ok := tcore.TErr("LoadMemStore", err); !ok {
+		t.Fatal(msg)
+	}
+
+	_ = first.Put(makeTestFile("kept.bin", 1000))
+	first.Terminate()
+
+	second, err := LoadMemStore(path)
+
+	if msg, ok := tcore.TErr("LoadMemStore", err); !ok {
+		t.Fatal(msg)
+	}
+
+	defer second.Terminate()
+	got, err := second.Get("kept.bin")
+
+	if msg, ok := tcore.TErr("second.Get", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if !bytes.Equal(got.Data, makeTestData(1000)) {
+		t.Error("the file did not survive the restart")
+	}
+
+	// only the snapshot is left in the directory, not its temporary file
+	entries, _ := ioutil.ReadDir(dir)
+
+	if msg, ok := tcore.TAssertInt("files in the directory", len(entries), 1); !ok {
+		t.Error(msg)
+	}
+
+	// a corrupt snapshot is refused at startup
+	_ = ioutil.WriteFile(path, []byte("not a tar archive, not at all"), 0644)
+
+	if _, err = LoadMemStore(path); err == nil {
+		t.Error("a corrupt snapshot should not be loaded")
+	}
+}
@@ -2,12 +2,20 @@
 
 package stor
 
-import "github.com/webern/tftp/lib/cor"
+import (
+	"bytes"
+	"io"
+	"math"
+
+	"github.com/webern/flog"
+	"github.com/webern/tftp/lib/cor"
+)
 
 // Store represents a mechanism for storing and retrieving files by name
 type Store interface {
 	// Put stores a file in the Store (overwriting if a file by the same name exists). Set is safe for concurrent
-	// goroutine access. The file is deep copied before storing.
+	// goroutine access. The file is deep copied before storing. Whether a client may replace a file is decided by the
+	// server's write policy, not the Store.
 	Put(f cor.File) error
 
 	// Get returns a file from the Store or an error if it is not found. Get is safe for concurrent goroutine access.
@@ -18,3 +26,155 @@ type Store interface {
 	// Terminate blocks until such operations are complete.
 	Terminate()
 }
+
+// Quota is optionally implemented by a Store that can only hold a limited number of bytes. The server uses it to
+// refuse uploads that cannot fit before any data is transferred. Stores made of other stores implement it by asking
+// the store that the file would be written to.
+type Quota interface {
+	// Remaining returns the number of bytes that can still be stored as the named file
+	Remaining(name string) int64
+}
+
+// Remaining returns the number of bytes that can still be stored in s as the named file, math.MaxInt64 if s does not
+// implement Quota
+func Remaining(s Store, name string) int64 {
+	if quota, ok := s.(Quota); ok {
+		return quota.Remaining(name)
+	}
+
+	return math.MaxInt64
+}
+
+// Checker is optionally implemented by a Store that can tell whether it holds a file without reading it. The server
+// uses it to refuse uploads of files that exist, see Exists.
+type Checker interface {
+	// Exists returns true if the store holds the named file. It neither reads the file nor counts as a use of it.
+	Exists(name string) bool
+}
+
+// Exists returns true if s holds the named file. It asks s itself if it implements Checker, and otherwise opens the
+// file, which for a store without Streamer reads the whole file.
+func Exists(s Store, name string) bool {
+	if checker, ok := s.(Checker); ok {
+		return checker.Exists(name)
+	}
+
+	r, _, err := AsStreamer(s).Open(name)
+
+	if err != nil {
+		return false
+	}
+
+	_ = r.Close()
+	return true
+}
+
+// WriteChecker is optionally implemented by a Store that refuses to write some or all files with an ErrAccess error.
+// Stores made of other stores use it to find the store that a file would be written to without creating the file.
+type WriteChecker interface {
+	// Writable returns false if the store refuses to write the named file. Nothing is created or written.
+	Writable(name string) bool
+}
+
+// Writable returns false if s implements WriteChecker and refuses to write the named file
+func Writable(s Store, name string) bool {
+	if checker, ok := s.(WriteChecker); ok {
+		return checker.Writable(name)
+	}
+
+	return true
+}
+
+// Streamer is optionally implemented by a Store that can read and write files a piece at a time, so that a transfer
+// does not hold the whole file in memory. The server uses it when it is available, see AsStreamer.
+type Streamer interface {
+	// Open returns a reader for the named file and the size of the file in bytes. The caller must close the reader.
+	Open(name string) (FileReader, int64, error)
+
+	// Create returns a writer for the named file. The file written is only stored, replacing any file by the same
+	// name, when Commit is called. Closing the writer without calling Commit discards it.
+	Create(name string) (FileWriter, error)
+}
+
+// FileReader reads a file from a Streamer
+type FileReader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// FileWriter writes a file to a Streamer
+type FileWriter interface {
+	io.WriteCloser
+
+	// Commit stores the file and closes the writer. Close may still be called afterward, it does nothing.
+	Commit() error
+}
+
+// AsStreamer returns s if it implements Streamer. Otherwise it returns an adapter that streams through s.Get and
+// s.Put, which holds each file in memory as before.
+func AsStreamer(s Store) Streamer {
+	if streamer, ok := s.(Streamer); ok {
+		return streamer
+	}
+
+	return storeStreamer{s}
+}
+
+// storeStreamer adapts a Store that does not implement Streamer
+type storeStreamer struct {
+	store Store
+}
+
+// Open gets the whole file and reads from it in memory
+func (s storeStreamer) Open(name string) (FileReader, int64, error) {
+	f, err := s.store.Get(name)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytesReader{bytes.NewReader(f.Data)}, int64(len(f.Data)), nil
+}
+
+// Create collects the file in memory and puts it when it is committed
+func (s storeStreamer) Create(name string) (FileWriter, error) {
+	return &bufferWriter{store: s.store, name: name}, nil
+}
+
+// bytesReader is a FileReader over a byte slice
+type bytesReader struct {
+	*bytes.Reader
+}
+
+func (bytesReader) Close() error { return nil }
+
+// bufferWriter is a FileWriter that puts the file to a Store when it is committed
+type bufferWriter struct {
+	store  Store
+	name   string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (b *bufferWriter) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, flog.Raise("the file has been closed")
+	}
+
+	return b.buf.Write(p)
+}
+
+func (b *bufferWriter) Commit() error {
+	if b.closed {
+		return flog.Raise("the file has been closed")
+	}
+
+	b.closed = true
+	return b.store.Put(cor.File{Name: b.name, Data: b.buf.Bytes()})
+}
+
+func (b *bufferWriter) Close() error {
+	b.closed = true
+	b.buf = bytes.Buffer{}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2019 by Matthew James Briggs, https://github.com/webern
+
+package stor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/webern/tcore"
+)
+
+func TestAsStreamer(t *testing.T) {
+	mstore := NewMemStore()
+	defer mstore.Terminate()
+
+	// memStore does not stream, so it is adapted
+	streamer := AsStreamer(mstore)
+
+	if _, ok := streamer.(storeStreamer); !ok {
+		t.Fatal("want the adapter for a memStore")
+	}
+
+	w, err := streamer.Create("streamed.bin")
+
+	if msg, ok := tcore.TErr("streamer.Create", err); !ok {
+		t.Fatal(msg)
+	}
+
+	data := makeTestData(1000)
+	_, _ = w.Write(data[:600])
+	_, _ = w.Write(data[600:])
+
+	// nothing is stored until the file is committed
+	if _, err = mstore.Get("streamed.bin"); err == nil {
+		t.Error("the file should not be stored before Commit")
+	}
+
+	if err = w.Commit(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_ = w.Close()
+	r, size, err := streamer.Open("streamed.bin")
+
+	if msg, ok := tcore.TErr("streamer.Open", err); !ok {
+		t.Fatal(msg)
+	}
+
+	if msg, ok := tcore.TAssertInt("size", int(size), len(data)); !ok {
+		t.Error(msg)
+	}
+
+	got, _ := ioutil.ReadAll(r)
+	_ = r.Close()
+
+	if !bytes.Equal(got, data) {
+		t.Error("the file read is not the file written")
+	}
+
+	// a file closed without being committed is discarded
+	w, _ = streamer.Create("discarded.bin")
+	_, _ = w.Write(data)
+	_ = w.Close()
+
+	if _, err = mstore.Get("discarded.bin"); err == nil {
+		t.Error("a file closed without Commit should not be stored")
+	}
+
+	if _, _, err = streamer.Open("discarded.bin"); err == nil {
+		t.Error("opening a file that does not exist should fail")
+	}
+}
+
+// plainStore hides every optional interface of the store it embeds
+type plainStore struct {
+	Store
+}
+
+func TestExists(t *testing.T) {
+	mstore := NewMemStore(WithBudget(200))
+	defer mstore.Terminate()
+
+	for _, name := range []string{"a", "b"} {
+		if err := mstore.Put(makeTestFile(name, 100)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if !Exists(mstore, "a") || Exists(mstore, "nope") {
+		t.Error("the memStore should hold a and not nope")
+	}
+
+	// checking for a does not make it the most recently used, so a is evicted to make room for c
+	if err := mstore.Put(makeTestFile("c", 100)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertHas(t, mstore, []string{"a", "b", "c"}, map[string]bool{"b": true, "c": true})
+
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+	dstore, _ := NewDirStore(dir)
+	defer dstore.Terminate()
+
+	if err := dstore.Put(makeTestFile("d", 10)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mounts, err := NewMountStore([]Mount{{Prefix: "mem", Store: ReadOnly(mstore)}, {Prefix: "disk", Store: dstore}})
+
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	overlay := NewOverlayStore([]Store{NewMemStore(), mounts})
+
+	tests := []struct {
+		store Store
+		name  string
+		want  bool
+	}{
+		{dstore, "d", true},
+		{dstore, "../d", false},
+		{dstore, "missing", false},
+		{mounts, "mem/b", true},
+		{mounts, "disk/d", true},
+		{mounts, "disk/b", false},
+		{mounts, "b", false},
+		{overlay, "mem/c", true},
+		{overlay, "mem/a", false},
+		{plainStore{mstore}, "b", true},
+		{plainStore{mstore}, "a", false},
+	}
+
+	for _, test := range tests {
+		if got := Exists(test.store, test.name); got != test.want {
+			t.Errorf("Exists(%T, %s) = %t, want %t", test.store, test.name, got, test.want)
+		}
+	}
+}